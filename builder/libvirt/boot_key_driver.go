@@ -0,0 +1,201 @@
+package libvirt
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+)
+
+// libvirt accepts at most this many keycodes in one virDomainSendKey call.
+const maxSendKeycodes = 16
+
+// keycodeLeftShift is the Linux input keycode of the left shift key.
+const keycodeLeftShift uint32 = 42
+
+// Linux input keycodes, see linux/input-event-codes.h
+var specialKeycodes = map[string]uint32{
+	"bs":         14,
+	"del":        111,
+	"down":       108,
+	"end":        107,
+	"enter":      28,
+	"esc":        1,
+	"f1":         59,
+	"f2":         60,
+	"f3":         61,
+	"f4":         62,
+	"f5":         63,
+	"f6":         64,
+	"f7":         65,
+	"f8":         66,
+	"f9":         67,
+	"f10":        68,
+	"f11":        87,
+	"f12":        88,
+	"home":       102,
+	"insert":     110,
+	"left":       105,
+	"leftalt":    56,
+	"leftctrl":   29,
+	"leftshift":  keycodeLeftShift,
+	"leftsuper":  125,
+	"menu":       127,
+	"pagedown":   109,
+	"pageup":     104,
+	"return":     28,
+	"right":      106,
+	"rightalt":   100,
+	"rightctrl":  97,
+	"rightshift": 54,
+	"rightsuper": 126,
+	"spacebar":   57,
+	"tab":        15,
+	"up":         103,
+}
+
+// The keycodes of a US keyboard are consecutive along each row, so each row
+// is recorded with the keycode of its first key, once unshifted and once
+// shifted.
+var runeKeycodeRows = map[string]uint32{
+	"1234567890-=": 2,
+	"!@#$%^&*()_+": 2,
+	"qwertyuiop[]": 16,
+	"QWERTYUIOP{}": 16,
+	"asdfghjkl;'`": 30,
+	`ASDFGHJKL:"~`: 30,
+	`\zxcvbnm,./`:  43,
+	"|ZXCVBNM<>?":  43,
+	" ":            57,
+	"\n":           28,
+	"\t":           15,
+}
+
+const shiftedRunes = `!@#$%^&*()_+{}:"~|<>?`
+
+// libvirtKeyDriver is a bootcommand.BCDriver that types through libvirt's
+// virDomainSendKey. virDomainSendKey always presses and releases keys
+// together, so keys turned on with <...On> are held and sent along with
+// every following key press until they're turned off again. A held key
+// that was never combined with another key is tapped on its own when it's
+// released.
+type libvirtKeyDriver struct {
+	driver   Driver
+	interval time.Duration
+	keycodes map[rune]uint32
+	held     []uint32
+	used     bool
+}
+
+func newLibvirtKeyDriver(driver Driver, interval time.Duration) *libvirtKeyDriver {
+	keyInterval := bootcommand.PackerKeyDefault
+	if delay, err := time.ParseDuration(os.Getenv(bootcommand.PackerKeyEnv)); err == nil {
+		keyInterval = delay
+	}
+	if interval > time.Duration(0) {
+		keyInterval = interval
+	}
+
+	keycodes := make(map[rune]uint32)
+	for chars, start := range runeKeycodeRows {
+		var i uint32
+		for len(chars) > 0 {
+			r, size := utf8.DecodeRuneInString(chars)
+			chars = chars[size:]
+			keycodes[r] = start + i
+			i++
+		}
+	}
+
+	return &libvirtKeyDriver{
+		driver:   driver,
+		interval: keyInterval,
+		keycodes: keycodes,
+	}
+}
+
+func (d *libvirtKeyDriver) SendKey(key rune, action bootcommand.KeyAction) error {
+	keycode, ok := d.keycodes[key]
+	if !ok {
+		return fmt.Errorf("no keycode found for character %q", key)
+	}
+
+	codes := []uint32{keycode}
+	if unicode.IsUpper(key) || strings.ContainsRune(shiftedRunes, key) {
+		codes = []uint32{keycodeLeftShift, keycode}
+	}
+
+	log.Printf("Sending char '%c', keycodes %v, action %s", key, codes, action)
+	return d.do(codes, action)
+}
+
+func (d *libvirtKeyDriver) SendSpecial(special string, action bootcommand.KeyAction) error {
+	keycode, ok := specialKeycodes[special]
+	if !ok {
+		return fmt.Errorf("special %s not found.", special)
+	}
+
+	log.Printf("Sending special '<%s>', keycode %d, action %s", special, keycode, action)
+	return d.do([]uint32{keycode}, action)
+}
+
+// Flush is a no-op, keys are sent as soon as they're pressed.
+func (d *libvirtKeyDriver) Flush() error {
+	return nil
+}
+
+func (d *libvirtKeyDriver) do(codes []uint32, action bootcommand.KeyAction) error {
+	switch action {
+	case bootcommand.KeyOn:
+		d.held = append(d.held, codes...)
+		d.used = false
+	case bootcommand.KeyOff:
+		var err error
+		if !d.used {
+			err = d.send(d.held)
+		}
+		d.release(codes)
+		return err
+	case bootcommand.KeyPress:
+		d.used = true
+		return d.send(append(append([]uint32{}, d.held...), codes...))
+	}
+	return nil
+}
+
+// release stops holding the given keycodes.
+func (d *libvirtKeyDriver) release(codes []uint32) {
+	held := d.held[:0]
+	for _, h := range d.held {
+		keep := true
+		for _, c := range codes {
+			if h == c {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			held = append(held, h)
+		}
+	}
+	d.held = held
+}
+
+func (d *libvirtKeyDriver) send(codes []uint32) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	if len(codes) > maxSendKeycodes {
+		return fmt.Errorf("too many keys pressed at once: %d, at most %d are allowed", len(codes), maxSendKeycodes)
+	}
+	if err := d.driver.SendKey(codes); err != nil {
+		return err
+	}
+	time.Sleep(d.interval)
+	return nil
+}
@@ -0,0 +1,40 @@
+package libvirt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_libvirtKeyDriver(t *testing.T) {
+	type testCase struct {
+		Command  string
+		Expected [][]uint32
+	}
+	testcases := []testCase{
+		{"ab", [][]uint32{{30}, {48}}},
+		{"A:", [][]uint32{{42, 30}, {42, 39}}},
+		{"<enter><f2>", [][]uint32{{28}, {60}}},
+		{"<leftCtrlOn>c<leftCtrlOff>", [][]uint32{{29, 46}}},
+		{"<leftAltOn><leftCtrlOn><del><leftCtrlOff><leftAltOff>", [][]uint32{{56, 29, 111}}},
+		{"<leftSuperOn><leftSuperOff>", [][]uint32{{125}}},
+		{"<wait1ms>x", [][]uint32{{45}}},
+	}
+
+	for _, tc := range testcases {
+		d := new(DriverMock)
+		kd := newLibvirtKeyDriver(d, time.Nanosecond)
+
+		seq, err := bootcommand.GenerateExpressionSequence(tc.Command)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Command, err)
+		}
+		if err := seq.Do(context.TODO(), kd); err != nil {
+			t.Fatalf("%s: err: %s", tc.Command, err)
+		}
+		assert.Equal(t, tc.Expected, d.SendKeyCalls, tc.Command)
+	}
+}
@@ -5,11 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"time"
 
 	"github.com/digitalocean/go-libvirt"
 	"github.com/hashicorp/hcl/v2/hcldec"
@@ -38,13 +36,37 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 }
 
 func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook) (packersdk.Artifact, error) {
+	// stepWatchDomain cancels the build when the domain dies
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// Create the driver that we'll use to communicate with Libvirt
-	driver, netName, err := b.newDriver(b.config.LibvirtAddr, b.config.NetBridge)
+	driver, netName, err := b.newDriver(&b.config)
 	if err != nil {
 		return nil, fmt.Errorf("Failed creating Libvirt driver: %s", err)
 	}
 
-	steps := []multistep.Step{}
+	// The communicator port is forwarded from the host with network_mode user
+	host := b.config.Comm.Host()
+	if host == "" && b.config.NetworkMode == "user" {
+		host = "127.0.0.1"
+	}
+	var connectStep multistep.Step = &communicator.StepConnect{
+		Config:    &b.config.Comm,
+		Host:      commHost(host, b.config.IPAddressSource, b.config.MACAddress, b.config.IPFamily),
+		SSHConfig: b.config.Comm.SSHConfigFunc(),
+		SSHPort:   commPort,
+		WinRMPort: commPort,
+	}
+	if b.config.Comm.Type == "guest-agent" {
+		connectStep = &stepConnectGuestAgent{
+			Timeout: b.config.GuestAgentTimeout,
+		}
+	}
+
+	steps := []multistep.Step{
+		new(stepCheckFirmware),
+	}
 	if !b.config.ISOSkipCache {
 		steps = append(steps, &commonsteps.StepDownload{
 			Checksum:    b.config.ISOChecksum,
@@ -61,6 +83,14 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		})
 	}
 
+	if !b.config.ISOSkipCache && !b.config.DiskImage {
+		steps = append(steps, &stepUploadISO{
+			ISOChecksum: b.config.ISOChecksum,
+			StoragePool: b.config.ISOStoragePool,
+			VMName:      b.config.VMName,
+		})
+	}
+
 	steps = append(steps, new(stepPrepareOutputDir),
 		&commonsteps.StepCreateFloppy{
 			Files:       b.config.FloppyConfig.FloppyFiles,
@@ -71,6 +101,10 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Files: b.config.CDConfig.CDFiles,
 			Label: b.config.CDConfig.CDLabel,
 		},
+		&stepUploadMedia{
+			StoragePool: b.config.ISOStoragePool,
+			VMName:      b.config.VMName,
+		},
 		&stepCreateDisk{
 			AdditionalDiskSize: b.config.AdditionalDiskSize,
 			DiskImage:          b.config.DiskImage,
@@ -80,6 +114,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			UseBackingFile:     b.config.UseBackingFile,
 			VMName:             b.config.VMName,
 			QemuImgArgs:        b.config.QemuImgArgs,
+			StoragePool:        b.config.StoragePool,
 		},
 		&stepCopyDisk{
 			DiskImage:      b.config.DiskImage,
@@ -87,6 +122,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			OutputDir:      b.config.OutputDir,
 			UseBackingFile: b.config.UseBackingFile,
 			VMName:         b.config.VMName,
+			StoragePool:    b.config.StoragePool,
 		},
 		&stepResizeDisk{
 			DiskCompression: b.config.DiskCompression,
@@ -97,7 +133,20 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			VMName:          b.config.VMName,
 			DiskSize:        b.config.DiskSize,
 			QemuImgArgs:     b.config.QemuImgArgs,
+			StoragePool:     b.config.StoragePool,
 		},
+	)
+
+	if b.config.EphemeralNetwork {
+		steps = append(steps, &stepCreateNetwork{
+			Range:  b.config.EphemeralNetworkRange,
+			VMName: b.config.VMName,
+		})
+	}
+
+	steps = append(steps,
+		new(stepReserveIP),
+		new(stepForwardPort),
 		new(stepHTTPIPDiscover),
 		&commonsteps.StepHTTPServer{
 			HTTPDir:     b.config.HTTPDir,
@@ -109,14 +158,15 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		&stepRun{
 			DiskImage: b.config.DiskImage,
 		},
-		&stepTypeBootCommand{},
-		&communicator.StepConnect{
-			Config:    &b.config.Comm,
-			Host:      commHost(b.config.Comm.Host()),
-			SSHConfig: b.config.Comm.SSHConfigFunc(),
-			SSHPort:   commPort,
-			WinRMPort: commPort,
+		&stepWatchDomain{
+			Cancel: cancel,
 		},
+		new(stepOpenConsole),
+		&stepScreenshot{
+			Interval: b.config.ScreenshotInterval,
+		},
+		&stepTypeBootCommand{},
+		connectStep,
 		new(commonsteps.StepProvision),
 		&commonsteps.StepCleanupTempKeys{
 			Comm: &b.config.Comm,
@@ -124,6 +174,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		&stepShutdown{
 			ShutdownTimeout: b.config.ShutdownTimeout,
 			ShutdownCommand: b.config.ShutdownCommand,
+			ShutdownMethod:  b.config.ShutdownMethod,
 			Comm:            &b.config.Comm,
 		},
 		&stepConvertDisk{
@@ -133,6 +184,8 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			SkipCompaction:  b.config.SkipCompaction,
 			VMName:          b.config.VMName,
 			QemuImgArgs:     b.config.QemuImgArgs,
+			StoragePool:     b.config.StoragePool,
+			UseBackingFile:  b.config.UseBackingFile,
 		},
 	)
 
@@ -148,6 +201,11 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	b.runner = commonsteps.NewRunnerWithPauseFn(steps, b.config.PackerConfig, ui, state)
 	b.runner.Run(ctx, state)
 
+	// If the domain died, that's why the build was cancelled
+	if rawErr, ok := state.GetOk("domain_error"); ok {
+		return nil, rawErr.(error)
+	}
+
 	// If there was an error, return that
 	if rawErr, ok := state.GetOk("error"); ok {
 		return nil, rawErr.(error)
@@ -163,19 +221,8 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	}
 
 	// Compile the artifact list
-	files := make([]string, 0, 5)
-	visit := func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			files = append(files, path)
-		}
-
-		return nil
-	}
-
-	if err := filepath.Walk(b.config.OutputDir, visit); err != nil {
+	files, err := artifactFiles(b.config.OutputDir, b.config.VMName)
+	if err != nil {
 		return nil, err
 	}
 
@@ -196,38 +243,79 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	artifact.state["diskType"] = b.config.Format
 	artifact.state["diskSize"] = b.config.DiskSize
 	artifact.state["hypervisor"] = b.config.Hypervisor
+	// placed in state in step_run.go for persistent UEFI domains
+	if nvramPath, ok := state.GetOk("nvram_path"); ok {
+		artifact.state["nvramPath"] = nvramPath
+	}
+	// placed in state in step_run.go when the TPM state is kept
+	if tpmStatePath, ok := state.GetOk("tpm_state_path"); ok {
+		artifact.state["tpmStatePath"] = tpmStatePath
+	}
 
 	return artifact, nil
 }
 
-func (b *Builder) newDriver(address string, netBridge string) (Driver, string, error) {
-	network := "unix"
-	if _, _, err := net.SplitHostPort(address); err == nil {
-		network = "tcp"
+func (b *Builder) newDriver(config *Config) (Driver, string, error) {
+	uri, err := parseLibvirtURI(config.LibvirtAddr)
+	if err != nil {
+		return nil, "", err
 	}
-	conn, err := net.DialTimeout(network, address, 2*time.Second)
+	dialer, err := uri.Dialer(config)
 	if err != nil {
-		return nil, "", fmt.Errorf("%s %s: %v", network, address, err)
+		return nil, "", err
 	}
-	l := libvirt.New(conn)
-	if err := l.Connect(); err != nil {
-		return nil, "", fmt.Errorf("%s %s: %v", network, address, err)
-
+	l := libvirt.NewWithDialer(dialer)
+	if err := l.ConnectToURI(uri.Name); err != nil {
+		return nil, "", fmt.Errorf("%s: %v", uri, err)
 	}
 
+	// qemu-img is only needed when the disks are managed locally
 	qemuImgPath, err := exec.LookPath("qemu-img")
-	if err != nil {
+	if err != nil && config.StoragePool == "" {
 		return nil, "", err
 	}
 
-	log.Printf("Libvirt connection info: %s %s, Qemu Image Path: %s", network, address, qemuImgPath)
+	log.Printf("Libvirt connection info: %s, Qemu Image Path: %s", uri, qemuImgPath)
 	driver := &LibvirtDriver{
 		libvirt:     l,
+		uri:         uri.Name,
 		QemuImgPath: qemuImgPath,
-		netBridge:   netBridge,
+		netBridge:   config.NetBridge,
+		persistent:  config.DomainType == "persistent",
+		keepTPM:     config.TPMPersistentState,
+	}
+	// The ephemeral network is only created by stepCreateNetwork, and
+	// bridge and direct interfaces don't need a libvirt network at all
+	if config.EphemeralNetwork || !config.usesNetBridge() {
+		return driver, "", nil
 	}
 	if err := driver.Verify(); err != nil {
 		return nil, "", err
 	}
 	return driver, driver.vmNet.Name, nil
 }
+
+// artifactFiles lists the files of the output directory that make up the
+// artifact. The console log and screenshots are only there to debug the
+// build, so they're left out.
+func artifactFiles(outputDir, vmName string) ([]string, error) {
+	files := make([]string, 0, 5)
+	visit := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path == filepath.Join(outputDir, screenshotDir) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && path != filepath.Join(outputDir, vmName+consoleLogSuffix) {
+			files = append(files, path)
+		}
+
+		return nil
+	}
+
+	if err := filepath.Walk(outputDir, visit); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
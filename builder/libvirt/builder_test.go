@@ -1,9 +1,13 @@
 package libvirt
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestBuilder_ImplementsBuilder(t *testing.T) {
@@ -13,3 +17,21 @@ func TestBuilder_ImplementsBuilder(t *testing.T) {
 		t.Error("Builder must implement builder.")
 	}
 }
+
+func Test_artifactFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, screenshotDir), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, name := range []string{"packer-test", "packer-test" + consoleLogSuffix, filepath.Join(screenshotDir, "packer-test-001-interval.png")} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	files, err := artifactFiles(dir, "packer-test")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, []string{filepath.Join(dir, "packer-test")}, files)
+}
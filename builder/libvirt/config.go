@@ -1,5 +1,5 @@
 //go:generate packer-sdc struct-markdown
-//go:generate packer-sdc mapstructure-to-hcl2 -type Config,QemuImgArgs
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config,QemuImgArgs,NetworkInterface
 
 package libvirt
 
@@ -7,10 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
 	"github.com/hashicorp/packer-plugin-sdk/common"
@@ -74,6 +76,32 @@ type QemuImgArgs struct {
 	Resize  []string `mapstructure:"resize" required:"false"`
 }
 
+// A network interface of the VM. At most one of `network`, `bridge` and
+// `direct` can be set; without any of them the interface is connected to the
+// network of `net_bridge`, or to the ephemeral network.
+type NetworkInterface struct {
+	// The name of the libvirt network to connect the interface to.
+	Network string `mapstructure:"network" required:"false"`
+	// The Linux bridge on the hypervisor to connect the interface to.
+	Bridge string `mapstructure:"bridge" required:"false"`
+	// The device on the hypervisor to attach a macvtap interface to. The
+	// hypervisor itself usually can't reach the VM through a macvtap
+	// interface in `bridge` mode.
+	Direct string `mapstructure:"direct" required:"false"`
+	// The macvtap mode of a `direct` interface, one of `bridge`, `vepa`,
+	// `private` or `passthrough`. This defaults to `bridge`.
+	DirectMode string `mapstructure:"direct_mode" required:"false"`
+	// The model of the interface. This defaults to `net_device`.
+	Model string `mapstructure:"model" required:"false"`
+	// The MAC address of the interface. By default a random address with
+	// the `52:54:00` prefix of QEMU is generated.
+	MACAddress string `mapstructure:"mac_address" required:"false"`
+	// Whether the communicator connects to the address of this interface.
+	// Only one interface can be used by the communicator, this defaults to
+	// the first one.
+	Communicator bool `mapstructure:"communicator" required:"false"`
+}
+
 type Config struct {
 	common.PackerConfig            `mapstructure:",squash"`
 	commonsteps.HTTPConfig         `mapstructure:",squash"`
@@ -189,9 +217,39 @@ type Config struct {
 	// will force the `skip_compaction` also to be true as well to skip disk
 	// conversion which would render the backing file feature useless.
 	UseBackingFile bool `mapstructure:"use_backing_file" required:"false"`
-	// The communacation address of libvirt. By default, this is
-	// /var/run/libvirt/libvirt-sock
+	// The libvirt storage pool in which the disks are created, resized and
+	// converted instead of running qemu-img on the Packer host. This allows
+	// building against a remote libvirtd; the final disks are downloaded into
+	// `output_directory` once the build is done. `disk_compression` and
+	// `qemu_img_args` are not supported in this mode. Unset by default.
+	StoragePool string `mapstructure:"storage_pool" required:"false"`
+	// The libvirt storage pool into which the downloaded ISO is uploaded
+	// before it is attached as CD-ROM. ISOs with a checksum are kept in the
+	// pool as `packer-<sha256>.iso`, named after their content, and reused by
+	// later builds. The floppy and the CD built from `cd_files` are uploaded
+	// into it too, for this build only. This defaults to `storage_pool`; when
+	// both are unset the local files are attached directly.
+	ISOStoragePool string `mapstructure:"iso_storage_pool" required:"false"`
+	// The communacation address of libvirt. This may be a libvirt connection
+	// URI such as `qemu:///system`, `qemu:///session`,
+	// `qemu+ssh://user@host/system`, `qemu+tcp://host/system` or
+	// `qemu+tls://host/system`, or, for backwards compatibility, a bare unix
+	// socket path or `host:port`. The `socket`, `keyfile`, `known_hosts`,
+	// `pkipath` and `no_verify` query parameters are honored the same way
+	// libvirt does. By default, this is /var/run/libvirt/libvirt-sock
 	LibvirtAddr string `mapstructure:"libvirt_addr" required:"false"`
+	// The private key used to log into the hypervisor when `libvirt_addr`
+	// uses the `qemu+ssh` transport. When unset, the keys of the running
+	// ssh-agent are used.
+	LibvirtSSHPrivateKeyFile string `mapstructure:"libvirt_ssh_private_key_file" required:"false"`
+	// The known_hosts file used to verify the hypervisor host key for the
+	// `qemu+ssh` transport. This defaults to `~/.ssh/known_hosts`.
+	LibvirtSSHKnownHostsFile string `mapstructure:"libvirt_ssh_known_hosts_file" required:"false"`
+	// The directory holding `cacert.pem`, `clientcert.pem` and `clientkey.pem`
+	// for the `qemu+tls` transport. By default the libvirt locations
+	// `/etc/pki/CA/cacert.pem`, `/etc/pki/libvirt/clientcert.pem` and
+	// `/etc/pki/libvirt/private/clientkey.pem` are used.
+	LibvirtTLSPKIPath string `mapstructure:"libvirt_tls_pki_path" required:"false"`
 	// The OS arch of emulation to use. Run `virsh capabilities` to
 	// list available types for your system. This defaults to `x86_64`.
 	Arch string `mapstructure:"arch" required:"false"`
@@ -201,6 +259,56 @@ type Config struct {
 	// The firmware which is specified by absolute path.
 	// It is useful when VM boot on UEFI Mode
 	Loader string `mapstructure:"loader" required:"false"`
+	// Let libvirt pick the firmware of the VM, `bios` or `efi`, instead of
+	// setting `loader`. Needs libvirt 7.2 or later. Whether the hypervisor
+	// offers the firmware, including Secure Boot, is only checked against
+	// its domain capabilities when the build starts, since `packer validate`
+	// doesn't connect to libvirt.
+	Firmware string `mapstructure:"firmware" required:"false"`
+	// Pick an `efi` firmware with Secure Boot. This turns on SMM, which
+	// needs a `q35` `machine_type`; that is checked by `packer validate`,
+	// whether the hypervisor has a Secure Boot firmware only when the build
+	// starts. Defaults to `false`.
+	SecureBoot bool `mapstructure:"secure_boot" required:"false"`
+	// Whether the Secure Boot firmware comes with the default keys enrolled
+	// in its NVRAM template, so signed operating systems boot right away.
+	// This defaults to `true` with `secure_boot`.
+	EnrolledKeys config.Trilean `mapstructure:"enrolled_keys" required:"false"`
+	// Whether the domain is `transient`, created from its XML and gone once
+	// it stops, or `persistent`, defined and then started, and undefined
+	// when the build is done. A persistent domain keeps the NVRAM variables
+	// of a UEFI `loader` across reboots. The NVRAM file is written to
+	// `output_directory` as `<vm_name>_VARS.fd` and is part of the artifact,
+	// unless `storage_pool` is set, then libvirt keeps it in its default
+	// location and removes it with the domain. This defaults to `transient`.
+	DomainType string `mapstructure:"domain_type" required:"false"`
+	// The NVRAM template the NVRAM of a persistent domain is created from,
+	// with `loader` or the `efi` `firmware`. By default libvirt picks the
+	// template of the firmware from the `nvram` setting of `qemu.conf`, or
+	// from the firmware descriptors with `firmware`.
+	NVRAMTemplate string `mapstructure:"nvram_template" required:"false"`
+	// Add an emulated TPM 2.0, backed by swtpm on the hypervisor, which
+	// Windows 11 and measured boot need. Defaults to `false`.
+	TPM bool `mapstructure:"tpm" required:"false"`
+	// The model of the TPM, `tpm-crb`, `tpm-tis`, or `tpm-tis-device` for
+	// `aarch64` VMs. Defaults to `tpm-crb`.
+	TPMModel string `mapstructure:"tpm_model" required:"false"`
+	// Keep the state of the TPM in the `<vm_name>_tpm` directory of the
+	// output directory, so it's part of the artifact. Needs libvirt 10.10 or
+	// later, and can't be used with `storage_pool`. Defaults to `false`.
+	TPMPersistentState bool `mapstructure:"tpm_persistent_state" required:"false"`
+	// The path of a kernel on the hypervisor to boot directly instead of
+	// booting from disk or CD-ROM. Together with `kernel_cmdline` this allows
+	// unattended installs without typing a `boot_command`; the boot command
+	// step is skipped when `boot_command` is empty.
+	Kernel string `mapstructure:"kernel" required:"false"`
+	// The path of an initrd on the hypervisor to use with `kernel`.
+	Initrd string `mapstructure:"initrd" required:"false"`
+	// The command line passed to `kernel`. This is a template engine and
+	// allows access to the following variables: {{ .HTTPIP }},
+	// {{ .HTTPPort }} and {{ .Name }}, e.g.
+	// `inst.ks=http://{{ .HTTPIP }}:{{ .HTTPPort }}/ks.cfg`.
+	KernelCmdline string `mapstructure:"kernel_cmdline" required:"false"`
 	// The CPU Mode to configure a guest CPU to be as close to host CPU as possible
 	// Allowed values `host-passthrough`, `host-model` and other value
 	// `host-passthrough` generate the following xml
@@ -231,6 +339,42 @@ type Config struct {
 	//
 	// **NB** This only works in Linux based OSes.
 	NetBridge string `mapstructure:"net_bridge" required:"false"`
+	// Create a transient NAT network with a subnet of its own for the build
+	// instead of using the network of `net_bridge`, and destroy it when the
+	// build is done. This keeps parallel builds apart. Defaults to `false`.
+	EphemeralNetwork bool `mapstructure:"ephemeral_network" required:"false"`
+	// The MAC address of the network interface of the VM. When the network
+	// has a DHCP server, an address is reserved for this MAC address before
+	// the VM boots and removed again after the build, so the address is
+	// available to `boot_command` as `{{ .GuestIP }}`. By default a random
+	// address with the `52:54:00` prefix of QEMU is generated.
+	MACAddress string `mapstructure:"mac_address" required:"false"`
+	// The network interfaces of the VM, each in a `network_interface`
+	// block. By default the VM has a single interface on the network of
+	// `net_bridge` with the model `net_device` and the MAC address
+	// `mac_address`, which can't be set together with `network_interface`.
+	// The DHCP reservation and the communicator use the interface marked
+	// with `communicator`.
+	NetworkInterfaces []NetworkInterface `mapstructure:"network_interface" required:"false"`
+	// The range the `/24` subnet of the ephemeral network is picked from.
+	// Subnets used by any other libvirt network are skipped. Defaults to
+	// `10.213.0.0/16`.
+	EphemeralNetworkRange string `mapstructure:"ephemeral_network_range" required:"false"`
+	// How the VM is connected to the network. `libvirt` connects the
+	// interfaces to libvirt networks, bridges or macvtap devices, see
+	// `network_interface`. `user` gives the VM a single interface on the
+	// user-mode network of QEMU instead, which needs no privileges and works
+	// with `qemu:///session`: the VM reaches the hypervisor, and so the HTTP
+	// server, on `10.0.2.2`, and the communicator connects to a port of
+	// `127.0.0.1` forwarded to the VM, so Packer must run on the hypervisor.
+	// This defaults to `libvirt`.
+	NetworkMode string `mapstructure:"network_mode" required:"false"`
+	// The minimum and maximum port of `127.0.0.1` to forward to the
+	// communicator port of the VM with `network_mode` `user`. Packer uses a
+	// randomly chosen port in this range that appears available. By default
+	// this is 2222 to 4444. The minimum and maximum ports are inclusive.
+	HostPortMin int `mapstructure:"host_port_min" required:"false"`
+	HostPortMax int `mapstructure:"host_port_max" required:"false"`
 	// This is the path to the directory where the
 	// resulting virtual machine will be created. This may be relative or absolute.
 	// If relative, the path is relative to the working directory when packer
@@ -240,9 +384,77 @@ type Config struct {
 	OutputDir string `mapstructure:"output_directory" required:"false"`
 	// Allow to control libvirt by customized xml
 	// This is a template engine and allows access to the following
-	// variables: {{ .HTTPIP }}, {{ .HTTPPort }}, {{ .HTTPDir }}, {{ .OutputDir }},
-	// and {{ .Name }}
+	// variables: {{ .VncIP }}, {{ .VncPort }}, {{ .VncPassword }}, {{ .VMName }},
+	// {{ .Disks }}, {{ .IsoPath }}, {{ .CDPath }}, {{ .MACAddress }},
+	// {{ .GuestIP }}, {{ .NVRAM }} and {{ .TPMStatePath }}. `CDPath` is the
+	// CD-ROM built from `cd_files`/`cd_content` and is empty when none is
+	// configured. `GuestIP` is the address reserved for `MACAddress`, see
+	// `mac_address`. `NVRAM` is the NVRAM file of a persistent domain, see
+	// `domain_type`. `TPMStatePath` is the TPM state directory, see
+	// `tpm_persistent_state`.
 	XMLFile string `mapstructure:"xml_file" required:"false"`
+	// How the `boot_command` is typed into the VM. `vnc` connects to the VNC
+	// server of the VM from the Packer host, `libvirt` sends the keys through
+	// the libvirt connection with `virDomainSendKey`, which also works when
+	// VNC is only reachable on the hypervisor or `disable_vnc` is set.
+	// Keys held with `<...On>` are pressed together with the next key.
+	// This defaults to `vnc`.
+	BootKeyDriver string `mapstructure:"boot_key_driver" required:"false"`
+	// How long a `<waitFor "regexp">` directive in `boot_command` waits for
+	// the regexp to appear on the serial console of the VM before the build
+	// fails. A single directive can override it with `<waitFor "regexp" 2m>`.
+	// The pattern is a double quoted Go string. This defaults to `5m`.
+	BootWaitForTimeout time.Duration `mapstructure:"boot_wait_for_timeout" required:"false"`
+	// The serial console of the VM is always written to
+	// `<output_directory>/<vm_name>-console.log`, which is kept when the
+	// build fails and isn't part of the artifact. Set this to `true` to also
+	// show every console line in the Packer output. Defaults to `false`.
+	ConsoleToUI bool `mapstructure:"console_to_ui" required:"false"`
+	// Take a screenshot of the VM this often while it runs, for example
+	// `30s`. Screenshots are saved as PNG to
+	// `<output_directory>/screenshots`, which is kept when the build fails
+	// and isn't part of the artifact. A screenshot is always taken when the
+	// build fails, and `boot_command` can take one with `<screenshot>`. By
+	// default no periodic screenshots are taken.
+	ScreenshotInterval time.Duration `mapstructure:"screenshot_interval" required:"false"`
+	// How the VM is shut down when `shutdown_command` is not set. `acpi`
+	// presses the ACPI power button, `agent` asks the QEMU guest agent to
+	// shut down the guest, which must run `qemu-guest-agent`. Both are
+	// repeated until the VM is off or `shutdown_timeout` expires, then the VM
+	// is forcefully stopped. `destroy` stops the VM forcefully right away.
+	// This defaults to `acpi`.
+	ShutdownMethod string `mapstructure:"shutdown_method" required:"false"`
+	// Where the IP address of the VM is looked up when the communicator has
+	// no `host` set. `lease` uses the DHCP leases of the libvirt network,
+	// `agent` asks the QEMU guest agent, which works for static addresses
+	// but needs `qemu-guest-agent` running in the guest, and `arp` uses the
+	// ARP table of the hypervisor. A list such as `["lease", "agent"]` is
+	// tried in order. The guest agent channel is always added to the VM.
+	// This defaults to `["lease"]`, or to `["agent", "arp"]` when the
+	// communicator uses a `bridge` or `direct` interface, which have no
+	// libvirt leases.
+	IPAddressSource []string `mapstructure:"ip_address_source" required:"false"`
+	// The address family of the VM address the communicator connects to and
+	// of the `{{ .HTTPIP }}` address the VM reaches the HTTP server on, one
+	// of `ipv4`, `ipv6` or `any`, which prefers IPv4. IPv6 addresses are
+	// enclosed in brackets, so `http://{{ .HTTPIP }}:{{ .HTTPPort }}/` works
+	// with either family. Link-local VM addresses are only used when the VM
+	// has no other one, with the zone of the bridge on the hypervisor, and
+	// only when libvirt runs on the same host as Packer, while
+	// `{{ .HTTPIP }}` is never link-local as the zone in the guest isn't
+	// known. This defaults to `ipv4`.
+	IPFamily string `mapstructure:"ip_family" required:"false"`
+	// The address the VM reaches the HTTP server on, available as
+	// `{{ .HTTPIP }}`. By default this is the address of `http_interface`
+	// on the Packer host when it is set, otherwise the gateway address of
+	// the libvirt network of the communicator interface, which also works
+	// with a remote hypervisor, or the address of the bridge or device of
+	// a `bridge` or `direct` interface on the Packer host.
+	HTTPIP string `mapstructure:"http_ip" required:"false"`
+	// How long to wait for the QEMU guest agent to answer when
+	// `communicator` is set to `guest-agent`, which runs commands and copies
+	// files through the agent instead of the network. This defaults to `20m`.
+	GuestAgentTimeout time.Duration `mapstructure:"guest_agent_timeout" required:"false"`
 	// The IP address that should be
 	// binded to for VNC. By default packer will use 127.0.0.1 for this. If you
 	// wish to bind to all interfaces use 0.0.0.0.
@@ -279,6 +491,7 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		InterpolateFilter: &interpolate.RenderFilter{
 			Exclude: []string{
 				"boot_command",
+				"kernel_cmdline",
 			},
 		},
 	}, raws...)
@@ -371,6 +584,9 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 	if c.LibvirtAddr == "" {
 		c.LibvirtAddr = "/var/run/libvirt/libvirt-sock"
 	}
+	if _, err := parseLibvirtURI(c.LibvirtAddr); err != nil {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
 
 	if c.MemorySize < 10 {
 		log.Printf("MemorySize %d is too small, using default: 512", c.MemorySize)
@@ -404,7 +620,13 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 
 	errs = packersdk.MultiErrorAppend(errs, c.FloppyConfig.Prepare(&c.ctx)...)
 	errs = packersdk.MultiErrorAppend(errs, c.CDConfig.Prepare(&c.ctx)...)
-	errs = packersdk.MultiErrorAppend(errs, c.VNCConfig.Prepare(&c.ctx)...)
+	// VNCConfig.Prepare refuses a boot command with disable_vnc, which
+	// is fine when the keys are sent through libvirt.
+	errs = packersdk.MultiErrorAppend(errs, c.VNCConfig.BootConfig.Prepare(&c.ctx)...)
+	if len(c.BootCommand) > 0 && c.DisableVNC && c.BootKeyDriver != "libvirt" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("A boot command cannot be used when vnc is disabled, unless boot_key_driver is 'libvirt'."))
+	}
 
 	if c.NetDevice == "" {
 		c.NetDevice = "virtio-net"
@@ -427,9 +649,20 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 
 	errs = packersdk.MultiErrorAppend(errs, c.HTTPConfig.Prepare(&c.ctx)...)
 
-	if es := c.Comm.Prepare(&c.ctx); len(es) > 0 {
+	// The communicator package doesn't know the guest-agent communicator,
+	// which needs no configuration of its own apart from the timeout.
+	if c.Comm.Type == "guest-agent" {
+		c.Comm.Type = "none"
+		if es := c.Comm.Prepare(&c.ctx); len(es) > 0 {
+			errs = packer.MultiErrorAppend(errs, es...)
+		}
+		c.Comm.Type = "guest-agent"
+	} else if es := c.Comm.Prepare(&c.ctx); len(es) > 0 {
 		errs = packer.MultiErrorAppend(errs, es...)
 	}
+	if c.GuestAgentTimeout == 0 {
+		c.GuestAgentTimeout = 20 * time.Minute
+	}
 
 	if !(c.Format == "qcow2" || c.Format == "raw") {
 		errs = packersdk.MultiErrorAppend(
@@ -449,6 +682,20 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		}
 	}
 
+	if c.ISOStoragePool == "" {
+		c.ISOStoragePool = c.StoragePool
+	}
+
+	if c.StoragePool != "" {
+		if c.DiskCompression {
+			errs = packersdk.MultiErrorAppend(
+				errs, errors.New("disk_compression can not be used with storage_pool"))
+		}
+		if len(c.QemuImgArgs.Convert) > 0 || len(c.QemuImgArgs.Create) > 0 || len(c.QemuImgArgs.Resize) > 0 {
+			warnings = append(warnings, "qemu_img_args is ignored when storage_pool is set")
+		}
+	}
+
 	if c.SkipResizeDisk && !(c.DiskImage) {
 		errs = packersdk.MultiErrorAppend(
 			errs, errors.New("skip_resize_disk can only be used when disk_image is true"))
@@ -492,6 +739,52 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		}
 	}
 
+	if c.BootWaitForTimeout == 0 {
+		c.BootWaitForTimeout = 5 * time.Minute
+	}
+
+	if c.BootKeyDriver == "" {
+		c.BootKeyDriver = "vnc"
+	}
+	if !(c.BootKeyDriver == "vnc" || c.BootKeyDriver == "libvirt") {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("invalid boot_key_driver, only 'vnc' or 'libvirt' are allowed"))
+	}
+
+	if c.ShutdownMethod == "" {
+		c.ShutdownMethod = "acpi"
+	}
+	if !(c.ShutdownMethod == "acpi" || c.ShutdownMethod == "agent" || c.ShutdownMethod == "destroy") {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("invalid shutdown_method, only 'acpi', 'agent' or 'destroy' are allowed"))
+	}
+
+	for _, source := range c.IPAddressSource {
+		if _, ok := ipAddressSources[source]; !ok {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("invalid ip_address_source %q, only 'lease', 'agent' or 'arp' are allowed", source))
+		}
+	}
+
+	if c.IPFamily == "" {
+		c.IPFamily = "ipv4"
+	}
+	switch c.IPFamily {
+	case "ipv4", "ipv6", "any":
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("invalid ip_family %q, only 'ipv4', 'ipv6' or 'any' are allowed", c.IPFamily))
+	}
+
+	if c.HTTPIP != "" && net.ParseIP(c.HTTPIP) == nil {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("invalid http_ip %q", c.HTTPIP))
+	}
+	if c.HTTPIP != "" && c.HTTPInterface != "" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("only one of http_ip and http_interface can be set"))
+	}
+
 	if c.VNCPortMin > c.VNCPortMax {
 		errs = packersdk.MultiErrorAppend(
 			errs, fmt.Errorf("vnc_port_min must be less than vnc_port_max"))
@@ -501,6 +794,143 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		c.NetBridge = "virbr0"
 	}
 
+	if c.NetworkMode == "" {
+		c.NetworkMode = "libvirt"
+	}
+	switch c.NetworkMode {
+	case "libvirt":
+	case "user":
+		if len(c.NetworkInterfaces) > 0 || c.EphemeralNetwork {
+			errs = packersdk.MultiErrorAppend(
+				errs, errors.New("network_interface and ephemeral_network can't be used with network_mode user"))
+		}
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("invalid network_mode %q, only 'libvirt' or 'user' are allowed", c.NetworkMode))
+	}
+	if c.HostPortMin == 0 {
+		c.HostPortMin = 2222
+	}
+	if c.HostPortMax == 0 {
+		c.HostPortMax = 4444
+	}
+	if c.HostPortMin > c.HostPortMax {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("host_port_min must be less than host_port_max"))
+	}
+
+	if len(c.NetworkInterfaces) > 0 && c.MACAddress != "" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("mac_address can't be used with network_interface, set it in the network_interface block"))
+	}
+	if len(c.NetworkInterfaces) == 0 {
+		c.NetworkInterfaces = []NetworkInterface{{MACAddress: c.MACAddress}}
+	}
+	communicators := 0
+	macs := map[string]bool{}
+	for i := range c.NetworkInterfaces {
+		iface := &c.NetworkInterfaces[i]
+		errs = packersdk.MultiErrorAppend(errs, iface.prepare(c.NetDevice)...)
+		if iface.Communicator {
+			communicators++
+		}
+		if mac := strings.ToLower(iface.MACAddress); macs[mac] {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("mac_address %s is used by more than one network_interface", iface.MACAddress))
+		} else {
+			macs[mac] = true
+		}
+	}
+	switch communicators {
+	case 0:
+		c.NetworkInterfaces[0].Communicator = true
+	case 1:
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("only one network_interface can be used by the communicator"))
+	}
+	c.MACAddress = c.CommunicatorInterface().MACAddress
+
+	if len(c.IPAddressSource) == 0 {
+		c.IPAddressSource = []string{"lease"}
+		if c.CommunicatorInterface().Type() != "network" {
+			c.IPAddressSource = []string{"agent", "arp"}
+		}
+	}
+
+	if c.EphemeralNetworkRange == "" {
+		c.EphemeralNetworkRange = "10.213.0.0/16"
+	}
+	if _, pool, err := net.ParseCIDR(c.EphemeralNetworkRange); err != nil {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("invalid ephemeral_network_range: %s", err))
+	} else if ones, bits := pool.Mask.Size(); bits != 32 || ones > 24 {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("ephemeral_network_range must be an IPv4 range of at least /24"))
+	}
+
+	if c.DomainType == "" {
+		c.DomainType = "transient"
+	}
+	switch c.DomainType {
+	case "transient", "persistent":
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("invalid domain_type %q, only 'transient' or 'persistent' are allowed", c.DomainType))
+	}
+	switch c.Firmware {
+	case "", "bios", "efi":
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("invalid firmware %q, only 'bios' or 'efi' are allowed", c.Firmware))
+	}
+	if c.Firmware != "" && c.Loader != "" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("only one of firmware and loader can be set"))
+	}
+	if c.SecureBoot && c.Firmware != "efi" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("secure_boot can only be used with firmware efi"))
+	}
+	if c.SecureBoot && !strings.Contains(c.MachineType, "q35") {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("secure_boot needs SMM, which needs a q35 machine_type"))
+	}
+	if c.EnrolledKeys != config.TriUnset && !c.SecureBoot {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("enrolled_keys can only be used with secure_boot"))
+	}
+	if c.SecureBoot && c.EnrolledKeys == config.TriUnset {
+		c.EnrolledKeys = config.TriTrue
+	}
+	if c.NVRAMTemplate != "" && ((c.Loader == "" && c.Firmware != "efi") || c.DomainType != "persistent") {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("nvram_template can only be used with loader or firmware efi, and domain_type persistent"))
+	}
+
+	if c.TPMModel == "" {
+		c.TPMModel = "tpm-crb"
+	}
+	switch c.TPMModel {
+	case "tpm-crb", "tpm-tis", "tpm-tis-device":
+	default:
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("invalid tpm_model %q, only 'tpm-crb', 'tpm-tis' or 'tpm-tis-device' are allowed", c.TPMModel))
+	}
+	if c.TPMPersistentState && !c.TPM {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("tpm_persistent_state can only be used with tpm"))
+	}
+	if c.TPMPersistentState && c.StoragePool != "" {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("tpm_persistent_state can't be used with storage_pool"))
+	}
+
+	if c.Kernel == "" && (c.Initrd != "" || c.KernelCmdline != "") {
+		errs = packersdk.MultiErrorAppend(
+			errs, errors.New("initrd and kernel_cmdline can only be used with kernel"))
+	}
+
 	if c.XMLFile != "" {
 		if _, err := os.Stat(c.XMLFile); err != nil {
 			errs = packersdk.MultiErrorAppend(
@@ -516,3 +946,79 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 	return warnings, nil
 
 }
+
+// CommunicatorInterface returns the network interface the communicator
+// connects to.
+func (c *Config) CommunicatorInterface() *NetworkInterface {
+	for i := range c.NetworkInterfaces {
+		if c.NetworkInterfaces[i].Communicator {
+			return &c.NetworkInterfaces[i]
+		}
+	}
+	return &c.NetworkInterfaces[0]
+}
+
+// usesNetBridge returns whether any network interface is connected to the
+// network of net_bridge, or to the ephemeral network.
+func (c *Config) usesNetBridge() bool {
+	if c.NetworkMode == "user" {
+		return false
+	}
+	for _, iface := range c.NetworkInterfaces {
+		if iface.Type() == "network" && iface.Network == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *NetworkInterface) prepare(defaultModel string) []error {
+	var errs []error
+
+	sources := 0
+	for _, source := range []string{i.Network, i.Bridge, i.Direct} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		errs = append(errs, errors.New("only one of network, bridge and direct can be set in a network_interface"))
+	}
+
+	if i.Direct == "" && i.DirectMode != "" {
+		errs = append(errs, errors.New("direct_mode can only be used with direct"))
+	}
+	if i.Direct != "" && i.DirectMode == "" {
+		i.DirectMode = "bridge"
+	}
+	switch i.DirectMode {
+	case "", "bridge", "vepa", "private", "passthrough":
+	default:
+		errs = append(errs, fmt.Errorf("invalid direct_mode %q, only 'bridge', 'vepa', 'private' or 'passthrough' are allowed", i.DirectMode))
+	}
+
+	if i.Model == "" {
+		i.Model = defaultModel
+	}
+
+	if i.MACAddress == "" {
+		i.MACAddress = randomMACAddress()
+	} else if mac, err := net.ParseMAC(i.MACAddress); err != nil || len(mac) != 6 {
+		errs = append(errs, fmt.Errorf("invalid mac_address %q", i.MACAddress))
+	} else if mac[0]&1 == 1 {
+		errs = append(errs, fmt.Errorf("mac_address %q must not be a multicast address", i.MACAddress))
+	}
+
+	return errs
+}
+
+// Type returns the libvirt type of the interface.
+func (i *NetworkInterface) Type() string {
+	switch {
+	case i.Bridge != "":
+		return "bridge"
+	case i.Direct != "":
+		return "direct"
+	}
+	return "network"
+}
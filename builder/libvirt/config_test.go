@@ -2,8 +2,11 @@ package libvirt
 
 import (
 	"io/ioutil"
+	"net"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -525,3 +528,458 @@ func TestBuilderPrepare_LoadQemuImgArgs(t *testing.T) {
 	assert.Equal(t, []string{"-baz", "bang"},
 		c.QemuImgArgs.Create, "Create args not loaded properly")
 }
+
+func TestBuilderPrepare_StoragePool(t *testing.T) {
+	var c Config
+	config := testConfig()
+	config["storage_pool"] = "default"
+	config["disk_compression"] = true
+
+	_, err := c.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	c = Config{}
+	config["disk_compression"] = false
+	config["qemu_img_args"] = map[string][]string{
+		"convert": []string{"-o", "preallocation=full"},
+	}
+	warns, err := c.Prepare(config)
+	if len(warns) != 1 {
+		t.Fatalf("should have warning about qemu_img_args: %#v", warns)
+	}
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+}
+
+func TestBuilderPrepare_Kernel(t *testing.T) {
+	var c Config
+	config := testConfig()
+	config["kernel_cmdline"] = "inst.ks=http://{{ .HTTPIP }}:{{ .HTTPPort }}/ks.cfg"
+
+	_, err := c.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	c = Config{}
+	config["kernel"] = "/var/lib/libvirt/boot/vmlinuz"
+	warns, err := c.Prepare(config)
+	if len(warns) > 0 {
+		t.Fatalf("bad: %#v", warns)
+	}
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if c.KernelCmdline != "inst.ks=http://{{ .HTTPIP }}:{{ .HTTPPort }}/ks.cfg" {
+		t.Fatalf("kernel_cmdline should not be interpolated during prepare: %s", c.KernelCmdline)
+	}
+}
+
+func TestBuilderPrepare_BootKeyDriver(t *testing.T) {
+	var c Config
+	config := testConfig()
+	config["boot_command"] = []string{"<enter>"}
+	config["disable_vnc"] = true
+
+	_, err := c.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	c = Config{}
+	config["boot_key_driver"] = "libvirt"
+	warns, err := c.Prepare(config)
+	if len(warns) > 0 {
+		t.Fatalf("bad: %#v", warns)
+	}
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	c = Config{}
+	config["boot_key_driver"] = "serial"
+	_, err = c.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_ShutdownMethod(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	warns, err := c.Prepare(config)
+	if len(warns) > 0 {
+		t.Fatalf("bad: %#v", warns)
+	}
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if c.ShutdownMethod != "acpi" {
+		t.Fatalf("bad shutdown method: %s", c.ShutdownMethod)
+	}
+
+	c = Config{}
+	config["shutdown_method"] = "agent"
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	c = Config{}
+	config["shutdown_method"] = "reboot"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_IPAddressSource(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if !reflect.DeepEqual(c.IPAddressSource, []string{"lease"}) {
+		t.Fatalf("bad ip_address_source: %#v", c.IPAddressSource)
+	}
+
+	c = Config{}
+	config["network_interface"] = []map[string]interface{}{{"bridge": "br0"}}
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if !reflect.DeepEqual(c.IPAddressSource, []string{"agent", "arp"}) {
+		t.Fatalf("bad ip_address_source: %#v", c.IPAddressSource)
+	}
+	assert.False(t, c.usesNetBridge())
+	delete(config, "network_interface")
+
+	c = Config{}
+	config["ip_address_source"] = []string{"agent", "arp"}
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	c = Config{}
+	config["ip_address_source"] = []string{"lease", "dns"}
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_GuestAgentCommunicator(t *testing.T) {
+	var c Config
+	config := testConfig()
+	config["communicator"] = "guest-agent"
+
+	warns, err := c.Prepare(config)
+	if len(warns) > 0 {
+		t.Fatalf("bad: %#v", warns)
+	}
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.Equal(t, "guest-agent", c.Comm.Type)
+	assert.Equal(t, 20*time.Minute, c.GuestAgentTimeout)
+}
+
+func TestBuilderPrepare_EphemeralNetworkRange(t *testing.T) {
+	var c Config
+	config := testConfig()
+	config["ephemeral_network"] = true
+
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.Equal(t, "10.213.0.0/16", c.EphemeralNetworkRange)
+
+	for _, r := range []string{"10.213.0.0/25", "fd00::/48", "nonsense"} {
+		c = Config{}
+		config["ephemeral_network_range"] = r
+		if _, err := c.Prepare(config); err == nil {
+			t.Fatalf("%s: should have error", r)
+		}
+	}
+}
+
+func TestBuilderPrepare_MACAddress(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if _, err := net.ParseMAC(c.MACAddress); err != nil {
+		t.Fatalf("should have generated a MAC address: %s", err)
+	}
+
+	c = Config{}
+	config["mac_address"] = "52:54:00:12:34:56"
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.Equal(t, "52:54:00:12:34:56", c.MACAddress)
+
+	for _, mac := range []string{"nonsense", "01:00:5e:00:00:01"} {
+		c = Config{}
+		config["mac_address"] = mac
+		if _, err := c.Prepare(config); err == nil {
+			t.Fatalf("%s: should have error", mac)
+		}
+	}
+}
+
+func TestBuilderPrepare_NetworkInterface(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if assert.Len(t, c.NetworkInterfaces, 1) {
+		assert.True(t, c.NetworkInterfaces[0].Communicator)
+		assert.Equal(t, "network", c.NetworkInterfaces[0].Type())
+		assert.Equal(t, c.MACAddress, c.NetworkInterfaces[0].MACAddress)
+	}
+
+	c = Config{}
+	config["network_interface"] = []map[string]interface{}{
+		{"network": "default"},
+		{"bridge": "br0", "mac_address": "52:54:00:12:34:57", "communicator": true},
+		{"direct": "eth1", "model": "e1000"},
+	}
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.Equal(t, "52:54:00:12:34:57", c.MACAddress)
+	assert.Equal(t, "bridge", c.CommunicatorInterface().Type())
+	assert.Equal(t, "bridge", c.NetworkInterfaces[2].DirectMode)
+	assert.Equal(t, "e1000", c.NetworkInterfaces[2].Model)
+	assert.Equal(t, "virtio-net", c.NetworkInterfaces[0].Model)
+
+	invalid := map[string][]map[string]interface{}{
+		"two sources":   {{"network": "default", "bridge": "br0"}},
+		"direct mode":   {{"direct": "eth1", "direct_mode": "nonsense"}},
+		"duplicate mac": {{"mac_address": "52:54:00:12:34:57"}, {"bridge": "br0", "mac_address": "52:54:00:12:34:57"}},
+		"communicators": {{"communicator": true}, {"bridge": "br0", "communicator": true}},
+	}
+	for name, ifaces := range invalid {
+		c = Config{}
+		config["network_interface"] = ifaces
+		if _, err := c.Prepare(config); err == nil {
+			t.Fatalf("%s: should have error", name)
+		}
+	}
+
+	c = Config{}
+	config["network_interface"] = []map[string]interface{}{{"network": "default"}}
+	config["mac_address"] = "52:54:00:12:34:56"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("mac_address with network_interface should have error")
+	}
+}
+
+func TestBuilderPrepare_NetworkMode(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.Equal(t, "libvirt", c.NetworkMode)
+
+	c = Config{}
+	config["network_mode"] = "user"
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.False(t, c.usesNetBridge())
+	assert.Equal(t, 2222, c.HostPortMin)
+	assert.Equal(t, 4444, c.HostPortMax)
+
+	c = Config{}
+	config["network_interface"] = []map[string]interface{}{{"bridge": "br0"}}
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("network_interface with network_mode user should have error")
+	}
+	delete(config, "network_interface")
+
+	c = Config{}
+	config["network_mode"] = "nonsense"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_IPFamily(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.Equal(t, "ipv4", c.IPFamily)
+
+	for _, family := range []string{"ipv6", "any"} {
+		c = Config{}
+		config["ip_family"] = family
+		if _, err := c.Prepare(config); err != nil {
+			t.Fatalf("%s: should not have error: %s", family, err)
+		}
+	}
+
+	c = Config{}
+	config["ip_family"] = "inet6"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_HTTPIP(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	config["http_ip"] = "192.168.122.1"
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	c = Config{}
+	config["http_ip"] = "nonsense"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	c = Config{}
+	config["http_ip"] = "192.168.122.1"
+	config["http_interface"] = "eth0"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_DomainType(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.Equal(t, "transient", c.DomainType)
+
+	c = Config{}
+	config["domain_type"] = "persistent"
+	config["loader"] = "/usr/share/OVMF/OVMF_CODE.fd"
+	config["nvram_template"] = "/usr/share/OVMF/OVMF_VARS.fd"
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	c = Config{}
+	config["domain_type"] = "transient"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("nvram_template with a transient domain should have error")
+	}
+
+	c = Config{}
+	config["domain_type"] = "nonsense"
+	delete(config, "nvram_template")
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_Firmware(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	config["firmware"] = "efi"
+	config["machine_type"] = "q35"
+	config["secure_boot"] = true
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.True(t, c.EnrolledKeys.True())
+
+	c = Config{}
+	config["enrolled_keys"] = false
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.True(t, c.EnrolledKeys.False())
+
+	c = Config{}
+	config["machine_type"] = "pc"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("secure_boot without q35 should have error")
+	}
+
+	c = Config{}
+	config["machine_type"] = "q35"
+	config["firmware"] = "bios"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("secure_boot with bios should have error")
+	}
+
+	c = Config{}
+	config["secure_boot"] = false
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("enrolled_keys without secure_boot should have error")
+	}
+
+	c = Config{}
+	delete(config, "enrolled_keys")
+	config["firmware"] = "efi"
+	config["loader"] = "/usr/share/OVMF/OVMF_CODE.fd"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("firmware with loader should have error")
+	}
+
+	c = Config{}
+	delete(config, "loader")
+	config["firmware"] = "nonsense"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_TPM(t *testing.T) {
+	var c Config
+	config := testConfig()
+
+	config["tpm"] = true
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	assert.Equal(t, "tpm-crb", c.TPMModel)
+
+	c = Config{}
+	config["tpm_model"] = "tpm-tis"
+	config["tpm_persistent_state"] = true
+	if _, err := c.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	c = Config{}
+	config["storage_pool"] = "default"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("tpm_persistent_state with storage_pool should have error")
+	}
+
+	c = Config{}
+	delete(config, "storage_pool")
+	config["tpm"] = false
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("tpm_persistent_state without tpm should have error")
+	}
+
+	c = Config{}
+	config["tpm"] = true
+	config["tpm_model"] = "nonsense"
+	if _, err := c.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
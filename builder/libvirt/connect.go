@@ -0,0 +1,386 @@
+package libvirt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/digitalocean/go-libvirt/socket"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultLibvirtSocket  = "/var/run/libvirt/libvirt-sock"
+	defaultLibvirtTCPPort = "16509"
+	defaultLibvirtTLSPort = "16514"
+	defaultSSHPort        = "22"
+
+	libvirtDialTimeout = 10 * time.Second
+)
+
+// libvirtURI is the parsed form of libvirt_addr. It knows how to reach the
+// daemon (Transport, Host, Port, Socket) and which driver URI to open once
+// the connection is established (Name).
+type libvirtURI struct {
+	Transport string
+	User      string
+	Host      string
+	Port      string
+	Socket    string
+	Name      libvirt.ConnectURI
+	Query     url.Values
+}
+
+// parseLibvirtURI accepts either a standard libvirt connection URI such as
+// qemu:///system, qemu+ssh://user@host/system or qemu+tls://host/system, or
+// the legacy forms of libvirt_addr: a bare unix socket path or host:port.
+func parseLibvirtURI(address string) (*libvirtURI, error) {
+	if !strings.Contains(address, "://") {
+		if strings.HasPrefix(address, "/") {
+			return &libvirtURI{
+				Transport: "unix",
+				Socket:    address,
+				Name:      libvirt.QEMUSystem,
+			}, nil
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid libvirt address %q: %s", address, err)
+		}
+		return &libvirtURI{
+			Transport: "tcp",
+			Host:      host,
+			Port:      port,
+			Name:      libvirt.QEMUSystem,
+		}, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid libvirt URI %q: %s", address, err)
+	}
+
+	driver, transport := u.Scheme, ""
+	if i := strings.Index(u.Scheme, "+"); i >= 0 {
+		driver, transport = u.Scheme[:i], u.Scheme[i+1:]
+	}
+	if driver == "" {
+		return nil, fmt.Errorf("invalid libvirt URI %q: missing driver", address)
+	}
+	if transport == "" {
+		// Same as libvirt: no host means the local daemon, a host without
+		// an explicit transport means TLS.
+		if u.Host == "" {
+			transport = "unix"
+		} else {
+			transport = "tls"
+		}
+	}
+
+	path := strings.TrimPrefix(u.Path, "/")
+	if path == "" {
+		path = "system"
+	}
+
+	r := &libvirtURI{
+		Transport: transport,
+		Host:      u.Hostname(),
+		Port:      u.Port(),
+		Socket:    u.Query().Get("socket"),
+		Name:      libvirt.ConnectURI(fmt.Sprintf("%s:///%s", driver, path)),
+		Query:     u.Query(),
+	}
+	if u.User != nil {
+		r.User = u.User.Username()
+	}
+
+	switch transport {
+	case "unix":
+		if r.Socket == "" {
+			r.Socket = localSocketPath(path)
+		}
+	case "ssh":
+		if r.Host == "" {
+			return nil, fmt.Errorf("invalid libvirt URI %q: ssh transport requires a host", address)
+		}
+		if r.Port == "" {
+			r.Port = defaultSSHPort
+		}
+		if r.Socket == "" {
+			r.Socket = defaultLibvirtSocket
+		}
+	case "tcp", "tls":
+		if r.Host == "" {
+			return nil, fmt.Errorf("invalid libvirt URI %q: %s transport requires a host", address, transport)
+		}
+		if r.Port == "" {
+			r.Port = defaultLibvirtTCPPort
+			if transport == "tls" {
+				r.Port = defaultLibvirtTLSPort
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid libvirt URI %q: unsupported transport %q", address, transport)
+	}
+
+	return r, nil
+}
+
+// localSocketPath returns the socket of the local daemon serving the given
+// URI path. Session daemons listen below $XDG_RUNTIME_DIR, falling back to
+// ~/.cache like libvirt itself does.
+func localSocketPath(path string) string {
+	if path != "session" {
+		return defaultLibvirtSocket
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "libvirt", "libvirt-sock")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cache", "libvirt", "libvirt-sock")
+}
+
+func (u *libvirtURI) String() string {
+	switch u.Transport {
+	case "unix":
+		return fmt.Sprintf("%s (unix %s)", u.Name, u.Socket)
+	case "ssh":
+		return fmt.Sprintf("%s (ssh %s@%s, socket %s)", u.Name, u.User, net.JoinHostPort(u.Host, u.Port), u.Socket)
+	default:
+		return fmt.Sprintf("%s (%s %s)", u.Name, u.Transport, net.JoinHostPort(u.Host, u.Port))
+	}
+}
+
+// isLocal reports whether libvirtd runs on this host, so the VMs' networks
+// are reachable from here.
+func (u *libvirtURI) isLocal() bool {
+	if u.Transport == "unix" {
+		return true
+	}
+	if u.Host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// noVerify reports whether the URI disables host verification, using the
+// same no_verify=1 query parameter as libvirt.
+func (u *libvirtURI) noVerify() bool {
+	return u.Query.Get("no_verify") == "1"
+}
+
+// Dialer returns a dialer that opens a fresh connection to the daemon every
+// time it's called, so the same URI can be used to reconnect.
+func (u *libvirtURI) Dialer(config *Config) (socket.Dialer, error) {
+	switch u.Transport {
+	case "unix":
+		return &netDialer{network: "unix", address: u.Socket}, nil
+	case "tcp":
+		return &netDialer{network: "tcp", address: net.JoinHostPort(u.Host, u.Port)}, nil
+	case "tls":
+		tlsConfig, err := u.tlsConfig(config.LibvirtTLSPKIPath)
+		if err != nil {
+			return nil, err
+		}
+		return &tlsDialer{address: net.JoinHostPort(u.Host, u.Port), config: tlsConfig}, nil
+	case "ssh":
+		sshConfig, agentSocket, err := u.sshConfig(config.LibvirtSSHPrivateKeyFile, config.LibvirtSSHKnownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+		return &sshDialer{
+			address:     net.JoinHostPort(u.Host, u.Port),
+			socket:      u.Socket,
+			config:      sshConfig,
+			agentSocket: agentSocket,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported libvirt transport %q", u.Transport)
+}
+
+// tlsConfig loads the x509 client certificate and CA from pkiPath, using the
+// same file names and default locations as libvirt.
+func (u *libvirtURI) tlsConfig(pkiPath string) (*tls.Config, error) {
+	if p := u.Query.Get("pkipath"); p != "" {
+		pkiPath = p
+	}
+
+	caCert := "/etc/pki/CA/cacert.pem"
+	clientCert := "/etc/pki/libvirt/clientcert.pem"
+	clientKey := "/etc/pki/libvirt/private/clientkey.pem"
+	if pkiPath == "" && os.Geteuid() != 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			if _, err := os.Stat(filepath.Join(home, ".pki", "libvirt")); err == nil {
+				pkiPath = filepath.Join(home, ".pki", "libvirt")
+			}
+		}
+	}
+	if pkiPath != "" {
+		caCert = filepath.Join(pkiPath, "cacert.pem")
+		clientCert = filepath.Join(pkiPath, "clientcert.pem")
+		clientKey = filepath.Join(pkiPath, "clientkey.pem")
+	}
+
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading libvirt client certificate: %s", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		ServerName:         u.Host,
+		InsecureSkipVerify: u.noVerify(),
+	}
+	if !tlsConfig.InsecureSkipVerify {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading libvirt CA certificate: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// sshConfig authenticates with the given private key, or returns the socket
+// of the running ssh-agent to authenticate with when no key file is
+// configured.
+func (u *libvirtURI) sshConfig(keyFile, knownHostsFile string) (*ssh.ClientConfig, string, error) {
+	if k := u.Query.Get("keyfile"); k != "" {
+		keyFile = k
+	}
+	if k := u.Query.Get("known_hosts"); k != "" {
+		knownHostsFile = k
+	}
+
+	user := u.User
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	var auth []ssh.AuthMethod
+	agentSocket := ""
+	if keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error reading libvirt ssh private key: %s", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error parsing libvirt ssh private key: %s", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		agentSocket = sock
+	} else {
+		return nil, "", fmt.Errorf("libvirt_ssh_private_key_file must be set when no ssh-agent is running")
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !u.noVerify() {
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, "", err
+			}
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		var err error
+		hostKeyCallback, err = knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error reading known_hosts file: %s", err)
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         libvirtDialTimeout,
+	}, agentSocket, nil
+}
+
+// netDialer dials a plain unix or tcp socket.
+type netDialer struct {
+	network string
+	address string
+}
+
+func (d *netDialer) Dial() (net.Conn, error) {
+	return net.DialTimeout(d.network, d.address, libvirtDialTimeout)
+}
+
+// tlsDialer dials libvirtd's TLS port with an x509 client certificate.
+type tlsDialer struct {
+	address string
+	config  *tls.Config
+}
+
+func (d *tlsDialer) Dial() (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: libvirtDialTimeout}, "tcp", d.address, d.config)
+}
+
+// sshDialer logs into the hypervisor over SSH and forwards the libvirt unix
+// socket through the SSH connection.
+type sshDialer struct {
+	address     string
+	socket      string
+	config      *ssh.ClientConfig
+	agentSocket string
+}
+
+func (d *sshDialer) Dial() (net.Conn, error) {
+	config := d.config
+	if d.agentSocket != "" {
+		// The agent is only needed to sign during the handshake, so it's
+		// connected for every dial and closed once ssh.Dial returns
+		agentConn, err := net.Dial("unix", d.agentSocket)
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to ssh-agent: %s", err)
+		}
+		defer agentConn.Close()
+		withAgent := *d.config
+		withAgent.Auth = []ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers)}
+		config = &withAgent
+	}
+
+	client, err := ssh.Dial("tcp", d.address, config)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial("unix", d.socket)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Error forwarding %s over ssh: %s", d.socket, err)
+	}
+	log.Printf("Forwarding libvirt socket %s over ssh %s", d.socket, d.address)
+	return &sshConn{Conn: conn, client: client}, nil
+}
+
+// sshConn closes the SSH client together with the forwarded socket.
+type sshConn struct {
+	net.Conn
+	client *ssh.Client
+}
+
+func (c *sshConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
@@ -0,0 +1,105 @@
+package libvirt
+
+import (
+	"testing"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseLibvirtURI(t *testing.T) {
+	type testCase struct {
+		Address   string
+		Transport string
+		User      string
+		Host      string
+		Port      string
+		Socket    string
+		Name      libvirt.ConnectURI
+		Reason    string
+	}
+	testcases := []testCase{
+		{"/var/run/libvirt/libvirt-sock", "unix", "", "", "", "/var/run/libvirt/libvirt-sock",
+			libvirt.QEMUSystem, "Legacy unix socket path"},
+		{"10.0.0.1:16509", "tcp", "", "10.0.0.1", "16509", "",
+			libvirt.QEMUSystem, "Legacy host:port"},
+		{"qemu:///system", "unix", "", "", "", "/var/run/libvirt/libvirt-sock",
+			libvirt.QEMUSystem, "Local system daemon"},
+		{"qemu:///system?socket=/tmp/sock", "unix", "", "", "", "/tmp/sock",
+			libvirt.QEMUSystem, "Local system daemon with socket override"},
+		{"qemu+ssh://root@hv1/system", "ssh", "root", "hv1", "22", "/var/run/libvirt/libvirt-sock",
+			libvirt.QEMUSystem, "SSH with default port"},
+		{"qemu+ssh://builder@hv1:2222/session?socket=/run/user/1000/libvirt/libvirt-sock", "ssh", "builder", "hv1", "2222",
+			"/run/user/1000/libvirt/libvirt-sock", libvirt.QEMUSession, "SSH to a session daemon"},
+		{"qemu+tls://hv1/system", "tls", "", "hv1", "16514", "",
+			libvirt.QEMUSystem, "TLS with default port"},
+		{"qemu://hv1/system", "tls", "", "hv1", "16514", "",
+			libvirt.QEMUSystem, "Remote host without transport defaults to TLS"},
+		{"qemu+tcp://hv1/system", "tcp", "", "hv1", "16509", "",
+			libvirt.QEMUSystem, "TCP with default port"},
+	}
+
+	for _, tc := range testcases {
+		u, err := parseLibvirtURI(tc.Address)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.Reason, err)
+		}
+		assert.Equal(t, tc.Transport, u.Transport, tc.Reason)
+		assert.Equal(t, tc.User, u.User, tc.Reason)
+		assert.Equal(t, tc.Host, u.Host, tc.Reason)
+		assert.Equal(t, tc.Port, u.Port, tc.Reason)
+		assert.Equal(t, tc.Socket, u.Socket, tc.Reason)
+		assert.Equal(t, tc.Name, u.Name, tc.Reason)
+	}
+}
+
+func Test_parseLibvirtURI_Invalid(t *testing.T) {
+	for _, address := range []string{
+		"not-an-address",
+		"qemu+ssh:///system",
+		"qemu+tls:///system",
+		"qemu+foo://host/system",
+	} {
+		if _, err := parseLibvirtURI(address); err == nil {
+			t.Errorf("%s: should have error", address)
+		}
+	}
+}
+
+func Test_libvirtURI_sshConfig_Agent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/packer-missing-agent.sock")
+	u, err := parseLibvirtURI("qemu+ssh://root@hv1/system?no_verify=1")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The agent is only connected to when dialing
+	config, agentSocket, err := u.sshConfig("", "")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "/tmp/packer-missing-agent.sock", agentSocket)
+	assert.Empty(t, config.Auth)
+
+	d := &sshDialer{address: "hv1:22", config: config, agentSocket: agentSocket}
+	if _, err := d.Dial(); err == nil {
+		t.Fatal("Dial should fail without an agent")
+	}
+}
+
+func Test_libvirtURI_isLocal(t *testing.T) {
+	for address, local := range map[string]bool{
+		"/var/run/libvirt/libvirt-sock": true,
+		"qemu:///system":                true,
+		"qemu+tcp://localhost/system":   true,
+		"qemu+tls://[::1]/system":       true,
+		"qemu+ssh://root@hv1/system":    false,
+		"10.0.0.1:16509":                false,
+	} {
+		u, err := parseLibvirtURI(address)
+		if err != nil {
+			t.Fatalf("%s: err: %s", address, err)
+		}
+		assert.Equal(t, local, u.isLocal(), address)
+	}
+}
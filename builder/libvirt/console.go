@@ -0,0 +1,125 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// consoleBufferSize bounds the console output kept for WaitFor.
+const consoleBufferSize = 64 * 1024
+
+// errConsoleStopped is returned by writes to a stopped serialConsole.
+var errConsoleStopped = errors.New("console stopped")
+
+// serialConsole receives the output of the domain's serial console from
+// libvirt. The output is kept for WaitFor and copied to every attached
+// writer.
+type serialConsole struct {
+	mu      sync.Mutex
+	buf     []byte
+	writers []io.Writer
+	changed chan struct{}
+	closed  bool
+	stopped bool
+	err     error
+}
+
+func newSerialConsole() *serialConsole {
+	return &serialConsole{changed: make(chan struct{})}
+}
+
+// Write implements io.Writer for the libvirt console stream.
+func (c *serialConsole) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return 0, errConsoleStopped
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) > consoleBufferSize {
+		c.buf = c.buf[len(c.buf)-consoleBufferSize:]
+	}
+	for _, w := range c.writers {
+		w.Write(p)
+	}
+	c.notify()
+
+	return len(p), nil
+}
+
+// AddWriter copies all further console output to w.
+func (c *serialConsole) AddWriter(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writers = append(c.writers, w)
+}
+
+// Stop detaches all writers and fails every further write, which ends the
+// libvirt console stream once more output arrives.
+func (c *serialConsole) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writers = nil
+	c.stopped = true
+}
+
+// Close marks the end of the console stream, err is the reason it ended.
+func (c *serialConsole) Close(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.err = err
+	c.notify()
+}
+
+// notify wakes up everyone waiting for console output. c.mu must be held.
+func (c *serialConsole) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// WaitFor blocks until re matches the console output that arrived since the
+// previous match, and discards the output up to the end of the match.
+func (c *serialConsole) WaitFor(ctx context.Context, re *regexp.Regexp, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		c.mu.Lock()
+		if loc := re.FindIndex(c.buf); loc != nil {
+			c.buf = c.buf[loc[1]:]
+			c.mu.Unlock()
+			return nil
+		}
+		if c.closed {
+			err := c.err
+			c.mu.Unlock()
+			if err == nil {
+				err = errors.New("console closed")
+			}
+			return fmt.Errorf("waiting for %q on the serial console: %s", re, err)
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return fmt.Errorf("timeout after %s waiting for %q on the serial console", timeout, re)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package libvirt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_serialConsole_WaitFor(t *testing.T) {
+	c := newSerialConsole()
+	var copied bytes.Buffer
+	c.AddWriter(&copied)
+
+	go func() {
+		c.Write([]byte("Booting...\r\n"))
+		time.Sleep(10 * time.Millisecond)
+		c.Write([]byte("login: "))
+	}()
+
+	if err := c.WaitFor(context.TODO(), regexp.MustCompile(`login:`), time.Second); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.WaitFor(context.TODO(), regexp.MustCompile(`login:`), 10*time.Millisecond); err == nil {
+		t.Fatal("should not match output consumed by the previous wait")
+	}
+	if copied.String() != "Booting...\r\nlogin: " {
+		t.Fatalf("bad copied output: %q", copied.String())
+	}
+
+	c.Close(errors.New("domain destroyed"))
+	if err := c.WaitFor(context.TODO(), regexp.MustCompile(`never`), time.Minute); err == nil {
+		t.Fatal("should have error once the console is closed")
+	}
+}
+
+func Test_serialConsole_Stop(t *testing.T) {
+	c := newSerialConsole()
+	var copied bytes.Buffer
+	c.AddWriter(&copied)
+
+	c.Write([]byte("Booting...\r\n"))
+	c.Stop()
+	if _, err := c.Write([]byte("login: ")); err != errConsoleStopped {
+		t.Fatalf("should have failed the write once stopped: %v", err)
+	}
+	if copied.String() != "Booting...\r\n" {
+		t.Fatalf("bad copied output: %q", copied.String())
+	}
+}
@@ -3,9 +3,11 @@ package libvirt
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"strings"
@@ -29,15 +31,107 @@ type Driver interface {
 	// Stop stops a running machine, forcefully.
 	Stop() error
 
+	// Shutdown asks the guest to shut down, either by pressing the ACPI
+	// power button ("acpi") or through the QEMU guest agent ("agent").
+	Shutdown(method string) error
+
 	// Start starts domain of libvirt
 	Start(Args ...string) error
 
+	// Undefine removes the definition of a persistent domain, and its
+	// NVRAM unless keepNVRAM is set. The TPM state is kept when it's part of
+	// the artifact.
+	Undefine(keepNVRAM bool) error
+
+	// SendKey presses the given Linux keycodes together on the domain's
+	// keyboard and releases them again.
+	SendKey(keycodes []uint32) error
+
+	// OpenConsole copies the output of the domain's serial console to w
+	// until the domain stops running. The console is reopened when the
+	// connection to libvirt is lost and comes back, unless ctx is done. A
+	// console stream can't be cancelled, it ends when w returns an error.
+	OpenConsole(ctx context.Context, w io.Writer) error
+
+	// Screenshot writes an image of the domain's first screen to w and
+	// returns its MIME type.
+	Screenshot(w io.Writer) (string, error)
+
+	// AgentCommand sends a QMP command to the QEMU guest agent of the domain
+	// and returns the JSON response.
+	AgentCommand(command string) (string, error)
+
+	// GetDomainIP returns the first address in the ip_family of the
+	// interface with the MAC address, or of any interface when none has it,
+	// found from the given ip_address_source values, tried in order.
+	// Link-local addresses are returned without
+	// their zone.
+	GetDomainIP(sources []string, mac string, family string) (string, error)
+
 	// wait on shutdown of the VM with option to cancel
 	WaitForShutdown(<-chan struct{}) bool
 
+	// DomainEnded returns a channel that is closed once the domain stops
+	// running.
+	DomainEnded() <-chan struct{}
+
+	// DomainEndError describes the last state of the domain once it
+	// stopped running.
+	DomainEndError() error
+
 	// Qemu executes the given command via qemu-img
 	QemuImg(...string) error
 
+	// CreateVolume creates a volume in the storage pool from the given XML,
+	// cloning the source volume when source is not empty, and returns the
+	// path of the new volume on the hypervisor.
+	CreateVolume(pool, xml, source string) (string, error)
+
+	// LookupVolume returns the path and capacity of a volume in the storage
+	// pool, or an empty path when the volume doesn't exist.
+	LookupVolume(pool, name string) (string, uint64, error)
+
+	// UploadVolume streams the local file into an existing volume.
+	UploadVolume(pool, name, path string) error
+
+	// DownloadVolume streams an existing volume into the local file.
+	DownloadVolume(pool, name, path string) error
+
+	// ResizeVolume sets the capacity of the volume in bytes.
+	ResizeVolume(pool, name string, size uint64) error
+
+	// DeleteVolume removes the volume from the storage pool.
+	DeleteVolume(pool, name string) error
+
+	// NetworkSubnets returns the subnets of all networks of the hypervisor.
+	NetworkSubnets() ([]*net.IPNet, error)
+
+	// CreateNetwork starts a transient network from the given XML and
+	// returns the name of its bridge.
+	CreateNetwork(xml string) (string, error)
+
+	// DestroyNetwork stops the transient network.
+	DestroyNetwork(name string) error
+
+	// NetworkDesc returns the XML definition of the network.
+	NetworkDesc(name string) (string, error)
+
+	// NetworkLeases returns the addresses currently leased by the DHCP
+	// server of the network.
+	NetworkLeases(name string) ([]string, error)
+
+	// AddDHCPHost adds the DHCP host reservation to the running network.
+	AddDHCPHost(network, hostXML string) error
+
+	// DeleteDHCPHost removes the DHCP host reservation from the running
+	// network.
+	DeleteDHCPHost(network, hostXML string) error
+
+	// DomainCapabilities returns the XML description of what the hypervisor
+	// supports for domains of the emulator, architecture, machine type and
+	// virtualization type.
+	DomainCapabilities(emulator, arch, machine, virtType string) (string, error)
+
 	// Verify checks to make sure that this driver should function
 	// properly. If there is any indication the driver can't function,
 	// this will return an error.
@@ -47,13 +141,31 @@ type Driver interface {
 	Version() (string, error)
 }
 
+// domainUndefineKeepTPM is VIR_DOMAIN_UNDEFINE_KEEP_TPM of libvirt 8.9,
+// which go-libvirt doesn't know yet.
+const domainUndefineKeepTPM libvirt.DomainUndefineFlagsValues = 64
+
+// reconnectTimeout is how long reconnecting to libvirt is retried, every
+// reconnectInterval, after the connection is lost.
+var (
+	reconnectTimeout  = 5 * time.Minute
+	reconnectInterval = 5 * time.Second
+)
+
 type LibvirtDriver struct {
 	libvirt     *libvirt.Libvirt
+	uri         libvirt.ConnectURI
 	netBridge   string
 	vmNet       libvirt.Network
 	QemuImgPath string
 	vmDomain    libvirt.Domain
-	vmEndCh     <-chan int
+	vmDefined   bool
+	vmRunning   bool
+	persistent  bool
+	keepTPM     bool
+	vmEndCh     chan struct{}
+	reconnectCh chan struct{}
+	vmEndErr    error
 	lock        sync.Mutex
 }
 
@@ -61,7 +173,8 @@ func (d *LibvirtDriver) Stop() error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	if d.vmDomain.ID != 0 {
+	// A defined domain that failed to start only needs Undefine
+	if d.vmRunning {
 		if err := d.libvirt.DomainDestroy(d.vmDomain); err != nil {
 			return err
 		}
@@ -70,6 +183,18 @@ func (d *LibvirtDriver) Stop() error {
 	return nil
 }
 
+func (d *LibvirtDriver) Shutdown(method string) error {
+	d.lock.Lock()
+	domain := d.vmDomain
+	d.lock.Unlock()
+
+	flags := libvirt.DomainShutdownAcpiPowerBtn
+	if method == "agent" {
+		flags = libvirt.DomainShutdownGuestAgent
+	}
+	return d.libvirt.DomainShutdownFlags(domain, flags)
+}
+
 func (d *LibvirtDriver) Copy(sourceName, targetName string) error {
 	source, err := os.Open(sourceName)
 	if err != nil {
@@ -101,55 +226,391 @@ func (d *LibvirtDriver) Start(Args ...string) error {
 
 	XMLDesc := Args[0]
 
-	log.Printf("Starting create domain from XML\n%s", XMLDesc)
-	domain, err := d.libvirt.DomainCreateXML(XMLDesc, 0)
+	// Subscribe before the domain exists, so none of its events are missed
+	events, cancel := d.lifecycleEvents()
+
+	var domain libvirt.Domain
+	var err error
+	if d.persistent {
+		domain, err = d.define(XMLDesc)
+	} else {
+		log.Printf("Starting create domain from XML\n%s", XMLDesc)
+		domain, err = d.libvirt.DomainCreateXML(XMLDesc, 0)
+	}
 	if err != nil {
+		cancel()
 		return err
 	}
 
-	endCh := make(chan int, 1)
+	endCh := make(chan struct{})
 	// Setup our state so we know we are running
+	d.lock.Lock()
 	d.vmEndCh = endCh
+	d.reconnectCh = make(chan struct{})
 	d.vmDomain = domain
+	d.vmRunning = true
+	d.lock.Unlock()
+
+	go d.watchDomain(domain, endCh, events, cancel)
+	return nil
+}
+
+// define defines a persistent domain and starts it. The domain stays
+// defined when it fails to start, so Undefine can clean it up.
+func (d *LibvirtDriver) define(XMLDesc string) (libvirt.Domain, error) {
+	log.Printf("Defining domain from XML\n%s", XMLDesc)
+	domain, err := d.libvirt.DomainDefineXMLFlags(XMLDesc, 0)
+	if err != nil {
+		return domain, err
+	}
+	d.lock.Lock()
+	d.vmDomain = domain
+	d.vmDefined = true
+	d.lock.Unlock()
+
+	log.Printf("Starting domain %s", domain.Name)
+	return d.libvirt.DomainCreateWithFlags(domain, 0)
+}
 
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-		errorState := -1
-		for range ticker.C {
-			state, _, err := d.libvirt.DomainGetState(d.vmDomain, 0)
+func (d *LibvirtDriver) Undefine(keepNVRAM bool) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.vmDefined {
+		return nil
+	}
+	flags := libvirt.DomainUndefineNvram
+	if keepNVRAM {
+		flags = libvirt.DomainUndefineKeepNvram
+	}
+	if d.keepTPM {
+		flags |= domainUndefineKeepTPM
+	}
+	log.Printf("Undefining domain %s", d.vmDomain.Name)
+	if err := d.libvirt.DomainUndefineFlags(d.vmDomain, flags); err != nil {
+		return err
+	}
+	d.vmDefined = false
+	return nil
+}
+
+// lifecycleEvents subscribes to the lifecycle events of all domains, or
+// returns a nil channel when libvirt is too old to send them.
+func (d *LibvirtDriver) lifecycleEvents() (<-chan libvirt.DomainEventLifecycleMsg, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := d.libvirt.LifecycleEvents(ctx)
+	if err != nil {
+		log.Printf("Lifecycle events are not supported, polling the domain state: %s", err)
+		cancel()
+		return nil, cancel
+	}
+	return events, cancel
+}
+
+// watchDomain waits until the domain stops running. When the connection to
+// libvirt is lost, e.g. because libvirtd restarted, it reconnects and keeps
+// watching the domain, which QEMU kept running. go-libvirt doesn't tell when
+// the connection is lost, so that is inferred from the lifecycle events
+// channel closing, or from DomainGetState failing when polling, and
+// confirmed by reconnect.
+func (d *LibvirtDriver) watchDomain(domain libvirt.Domain, endCh chan struct{}, events <-chan libvirt.DomainEventLifecycleMsg, cancel context.CancelFunc) {
+	for {
+		var endErr error
+		if events != nil {
+			endErr = d.waitForEvents(domain, events, cancel)
+		} else {
+			endErr = d.pollDomainState(domain)
+		}
+		if endErr != nil {
+			d.endDomain(endCh, endErr)
+			return
+		}
+
+		var err error
+		domain, err = d.reconnect(domain)
+		if libvirt.IsNotFound(err) {
+			// Transient domains are gone once they are shut off
+			d.endDomain(endCh, fmt.Errorf("domain %s is shut off", domain.Name))
+			return
+		} else if err != nil {
+			d.endDomain(endCh, fmt.Errorf("domain %s is gone: %s", domain.Name, err))
+			return
+		}
+		// Wake up OpenConsole, so it reopens the console on the new
+		// connection
+		d.lock.Lock()
+		d.vmDomain = domain
+		close(d.reconnectCh)
+		d.reconnectCh = make(chan struct{})
+		d.lock.Unlock()
+
+		// The events sent while disconnected are lost, so the state is
+		// checked once the new subscription is in place
+		events, cancel = d.lifecycleEvents()
+		if endErr, err := d.domainStateError(domain); endErr != nil || err != nil {
+			cancel()
 			if err != nil {
-				log.Printf("Error getting domain state: %s", err)
-				errorState = 99
-			} else if libvirt.DomainState(state) != libvirt.DomainRunning {
-				log.Printf("Domain state is %d", state)
-				errorState = int(state)
-			}
-			if errorState != -1 {
-				endCh <- errorState
-				d.lock.Lock()
-				d.vmDomain.ID = 0
-				d.lock.Unlock()
-				return
+				endErr = fmt.Errorf("domain %s is gone: %s", domain.Name, err)
 			}
+			d.endDomain(endCh, endErr)
+			return
+		}
+	}
+}
+
+// waitForEvents returns why the domain stopped running from its lifecycle
+// events, or nil when the events stop before that.
+func (d *LibvirtDriver) waitForEvents(domain libvirt.Domain, events <-chan libvirt.DomainEventLifecycleMsg, cancel context.CancelFunc) error {
+	defer func() {
+		// The events have to be drained until the channel closes
+		cancel()
+		for range events {
 		}
 	}()
-	return err
+
+	for ev := range events {
+		if ev.Dom.UUID != domain.UUID {
+			continue
+		}
+		log.Printf("Domain event %d, detail %d", ev.Event, ev.Detail)
+		if endErr := lifecycleEventError(domain, ev); endErr != nil {
+			return endErr
+		}
+	}
+	log.Printf("Lifecycle events of domain %s stopped", domain.Name)
+	return nil
+}
+
+// pollDomainState checks the state of the domain every 5 seconds until it
+// stops running, for libvirt versions without lifecycle events. It returns
+// nil when the state can't be read anymore.
+func (d *LibvirtDriver) pollDomainState(domain libvirt.Domain) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		endErr, err := d.domainStateError(domain)
+		if err != nil {
+			log.Printf("Error getting domain state: %s", err)
+			return nil
+		}
+		if endErr != nil {
+			return endErr
+		}
+	}
+	return nil
+}
+
+// domainStateError returns why the domain isn't running anymore, or nil
+// when it is. err is set when the state can't be read.
+func (d *LibvirtDriver) domainStateError(domain libvirt.Domain) (endErr error, err error) {
+	state, reason, err := d.libvirt.DomainGetState(domain, 0)
+	if libvirt.IsNotFound(err) {
+		// Transient domains are gone once they are shut off
+		return fmt.Errorf("domain %s is shut off", domain.Name), nil
+	} else if err != nil {
+		return nil, err
+	}
+	if s := libvirt.DomainState(state); s != libvirt.DomainRunning && s != libvirt.DomainBlocked {
+		log.Printf("Domain state is %d, reason %d", state, reason)
+		return fmt.Errorf("domain %s is %s", domain.Name, describeDomainState(s, reason)), nil
+	}
+	return nil, nil
+}
+
+// reconnect connects to libvirt again with the original URI when the
+// connection is lost, and looks the domain up again by UUID.
+func (d *LibvirtDriver) reconnect(domain libvirt.Domain) (libvirt.Domain, error) {
+	if _, err := d.libvirt.ConnectGetLibVersion(); err != nil {
+		log.Printf("Lost the connection to libvirt (%s), reconnecting to %s...", err, d.uri)
+		deadline := time.Now().Add(reconnectTimeout)
+		for {
+			err := d.libvirt.ConnectToURI(d.uri)
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return domain, fmt.Errorf("Error reconnecting to libvirt: %s", err)
+			}
+			log.Printf("Error reconnecting to libvirt, retrying in %s: %s", reconnectInterval, err)
+			time.Sleep(reconnectInterval)
+		}
+		log.Printf("Reconnected to libvirt at %s", d.uri)
+	}
+
+	found, err := d.libvirt.DomainLookupByUUID(domain.UUID)
+	if err != nil {
+		return domain, err
+	}
+	return found, nil
+}
+
+// endDomain records why the domain stopped running and wakes up everything
+// waiting for it.
+func (d *LibvirtDriver) endDomain(endCh chan struct{}, endErr error) {
+	d.lock.Lock()
+	d.vmDomain.ID = 0
+	d.vmRunning = false
+	d.vmEndErr = endErr
+	d.lock.Unlock()
+	close(endCh)
+}
+
+// lifecycleEventError returns why the domain stopped running for the
+// lifecycle events that end it, or nil for the others. The details of these
+// events are the domain state reasons, shifted by one.
+func lifecycleEventError(domain libvirt.Domain, ev libvirt.DomainEventLifecycleMsg) error {
+	switch libvirt.DomainEventType(ev.Event) {
+	case libvirt.DomainEventStopped:
+		return fmt.Errorf("domain %s is %s", domain.Name, describeDomainState(libvirt.DomainShutoff, ev.Detail+1))
+	case libvirt.DomainEventCrashed:
+		return fmt.Errorf("domain %s is %s", domain.Name, describeDomainState(libvirt.DomainCrashed, ev.Detail+1))
+	}
+	return nil
+}
+
+// describeDomainState returns the name of the domain state and of the reason
+// it's in that state.
+func describeDomainState(state libvirt.DomainState, reason int32) string {
+	switch state {
+	case libvirt.DomainShutoff:
+		reasons := map[libvirt.DomainShutoffReason]string{
+			libvirt.DomainShutoffShutdown:  "shut down by the guest",
+			libvirt.DomainShutoffDestroyed: "destroyed",
+			libvirt.DomainShutoffCrashed:   "crashed",
+			libvirt.DomainShutoffFailed:    "failed to start",
+			libvirt.DomainShutoffDaemon:    "stopped by the daemon",
+		}
+		if r, ok := reasons[libvirt.DomainShutoffReason(reason)]; ok {
+			return "shut off: " + r
+		}
+		return fmt.Sprintf("shut off (reason %d)", reason)
+	case libvirt.DomainCrashed:
+		if libvirt.DomainCrashedReason(reason) == libvirt.DomainCrashedPanicked {
+			return "crashed: the guest panicked"
+		}
+		return fmt.Sprintf("crashed (reason %d)", reason)
+	case libvirt.DomainPaused:
+		return fmt.Sprintf("paused (reason %d)", reason)
+	case libvirt.DomainShutdown:
+		return "shutting down"
+	case libvirt.DomainPmsuspended:
+		return "suspended by the guest"
+	}
+	return fmt.Sprintf("in state %d (reason %d)", state, reason)
+}
+
+func (d *LibvirtDriver) SendKey(keycodes []uint32) error {
+	d.lock.Lock()
+	domain := d.vmDomain
+	d.lock.Unlock()
+
+	return d.libvirt.DomainSendKey(domain, uint32(libvirt.KeycodeSetLinux), 0, keycodes, 0)
 }
 
-func (d *LibvirtDriver) GetDomainIP() (string, error) {
-	ifaces, err := d.libvirt.DomainInterfaceAddresses(d.vmDomain, 0, 0)
+func (d *LibvirtDriver) OpenConsole(ctx context.Context, w io.Writer) error {
+	for {
+		d.lock.Lock()
+		domain, endCh, reconnectCh := d.vmDomain, d.vmEndCh, d.reconnectCh
+		d.lock.Unlock()
+
+		log.Printf("Opening serial console of domain %s", domain.Name)
+		err := d.libvirt.DomainOpenConsole(domain, libvirt.OptString{}, w, uint32(libvirt.DomainConsoleForce))
+
+		// The console stream also ends when the connection to libvirt is
+		// lost, while the domain keeps running
+		select {
+		case <-reconnectCh:
+			log.Printf("Console of domain %s closed (%v), reopening it after reconnecting", domain.Name, err)
+		case <-endCh:
+			return err
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (d *LibvirtDriver) Screenshot(w io.Writer) (string, error) {
+	d.lock.Lock()
+	domain := d.vmDomain
+	d.lock.Unlock()
+
+	mime, err := d.libvirt.DomainScreenshot(domain, w, 0, 0)
 	if err != nil {
+		return "", err
+	}
+	if len(mime) == 0 {
 		return "", nil
 	}
+	return mime[0], nil
+}
+
+func (d *LibvirtDriver) AgentCommand(command string) (string, error) {
+	d.lock.Lock()
+	domain := d.vmDomain
+	d.lock.Unlock()
+
+	result, err := d.libvirt.QEMUDomainAgentCommand(domain, command, int32(libvirt.DomainAgentResponseTimeoutDefault), 0)
+	if err != nil {
+		return "", err
+	}
+	if len(result) == 0 {
+		return "", nil
+	}
+	return result[0], nil
+}
+
+// ipAddressSources maps ip_address_source values to the libvirt sources of
+// DomainInterfaceAddresses.
+var ipAddressSources = map[string]libvirt.DomainInterfaceAddressesSource{
+	"lease": libvirt.DomainInterfaceAddressesSrcLease,
+	"agent": libvirt.DomainInterfaceAddressesSrcAgent,
+	"arp":   libvirt.DomainInterfaceAddressesSrcArp,
+}
+
+func (d *LibvirtDriver) GetDomainIP(sources []string, mac string, family string) (string, error) {
+	d.lock.Lock()
+	domain := d.vmDomain
+	d.lock.Unlock()
+
+	var errs []string
+	for _, source := range sources {
+		ifaces, err := d.libvirt.DomainInterfaceAddresses(domain, uint32(ipAddressSources[source]), 0)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", source, err))
+			continue
+		}
+		if ip := pickIP(interfaceIPs(ifaces, mac), family); ip != nil {
+			log.Printf("Found address %s of domain %s from %s", ip, domain.Name, source)
+			return ip.String(), nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: no %s address", source, family))
+	}
+	return "", fmt.Errorf("No %s address for interface %s of domain %s (%s)", family, mac, domain.Name, strings.Join(errs, ", "))
+}
+
+// interfaceIPs returns the addresses of the interface with the MAC address.
+// When no interface has it, as with an xml_file template that doesn't use
+// the MAC address, the addresses of all interfaces are returned.
+func interfaceIPs(ifaces []libvirt.DomainInterface, mac string) []net.IP {
+	matched := false
 	for _, iface := range ifaces {
+		if len(iface.Hwaddr) > 0 && strings.EqualFold(iface.Hwaddr[0], mac) {
+			matched = true
+		}
+	}
+
+	var ips []net.IP
+	for _, iface := range ifaces {
+		if matched && (len(iface.Hwaddr) == 0 || !strings.EqualFold(iface.Hwaddr[0], mac)) {
+			continue
+		}
 		for _, addr := range iface.Addrs {
-			if addr.Type == int32(libvirt.IPAddrTypeIpv4) {
-				return addr.Addr, nil
+			if ip := net.ParseIP(addr.Addr); ip != nil {
+				ips = append(ips, ip)
 			}
 		}
 	}
-	return "", fmt.Errorf("No ipv4 address for domain %s", d.vmDomain.Name)
+	return ips
 }
 
 func (d *LibvirtDriver) WaitForShutdown(cancelCh <-chan struct{}) bool {
@@ -169,6 +630,18 @@ func (d *LibvirtDriver) WaitForShutdown(cancelCh <-chan struct{}) bool {
 	}
 }
 
+func (d *LibvirtDriver) DomainEnded() <-chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.vmEndCh
+}
+
+func (d *LibvirtDriver) DomainEndError() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.vmEndErr
+}
+
 func (d *LibvirtDriver) QemuImg(args ...string) error {
 	var stdout, stderr bytes.Buffer
 
@@ -191,6 +664,219 @@ func (d *LibvirtDriver) QemuImg(args ...string) error {
 	return err
 }
 
+func (d *LibvirtDriver) lookupVolume(poolName, name string) (libvirt.StorageVol, error) {
+	pool, err := d.libvirt.StoragePoolLookupByName(poolName)
+	if err != nil {
+		return libvirt.StorageVol{}, err
+	}
+	return d.libvirt.StorageVolLookupByName(pool, name)
+}
+
+func (d *LibvirtDriver) CreateVolume(poolName, xml, source string) (string, error) {
+	pool, err := d.libvirt.StoragePoolLookupByName(poolName)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Creating volume in pool %s from XML\n%s", poolName, xml)
+	var vol libvirt.StorageVol
+	if source != "" {
+		sourceVol, err := d.libvirt.StorageVolLookupByName(pool, source)
+		if err != nil {
+			return "", err
+		}
+		vol, err = d.libvirt.StorageVolCreateXMLFrom(pool, xml, sourceVol, 0)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		vol, err = d.libvirt.StorageVolCreateXML(pool, xml, 0)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return d.libvirt.StorageVolGetPath(vol)
+}
+
+// isVolumeExists reports whether err is libvirt refusing to create a volume
+// because one with the same name already exists.
+func isVolumeExists(err error) bool {
+	e, ok := err.(libvirt.Error)
+	return ok && e.Code == uint32(libvirt.ErrStorageVolExist)
+}
+
+func (d *LibvirtDriver) LookupVolume(poolName, name string) (string, uint64, error) {
+	vol, err := d.lookupVolume(poolName, name)
+	if err != nil {
+		if e, ok := err.(libvirt.Error); ok && e.Code == uint32(libvirt.ErrNoStorageVol) {
+			return "", 0, nil
+		}
+		return "", 0, err
+	}
+
+	_, capacity, _, err := d.libvirt.StorageVolGetInfo(vol)
+	if err != nil {
+		return "", 0, err
+	}
+	path, err := d.libvirt.StorageVolGetPath(vol)
+	return path, capacity, err
+}
+
+func (d *LibvirtDriver) UploadVolume(poolName, name, path string) error {
+	vol, err := d.lookupVolume(poolName, name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Uploading %s (%d bytes) to volume %s/%s", path, info.Size(), poolName, name)
+	return d.libvirt.StorageVolUpload(vol, f, 0, uint64(info.Size()), 0)
+}
+
+func (d *LibvirtDriver) DownloadVolume(poolName, name, path string) error {
+	vol, err := d.lookupVolume(poolName, name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	log.Printf("Downloading volume %s/%s to %s", poolName, name, path)
+	return d.libvirt.StorageVolDownload(vol, f, 0, 0, 0)
+}
+
+func (d *LibvirtDriver) ResizeVolume(poolName, name string, size uint64) error {
+	vol, err := d.lookupVolume(poolName, name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Resizing volume %s/%s to %d bytes", poolName, name, size)
+	return d.libvirt.StorageVolResize(vol, size, 0)
+}
+
+func (d *LibvirtDriver) DeleteVolume(poolName, name string) error {
+	vol, err := d.lookupVolume(poolName, name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleting volume %s/%s", poolName, name)
+	return d.libvirt.StorageVolDelete(vol, libvirt.StorageVolDeleteNormal)
+}
+
+func (d *LibvirtDriver) NetworkSubnets() ([]*net.IPNet, error) {
+	networks, _, err := d.libvirt.ConnectListAllNetworks(1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var subnets []*net.IPNet
+	for _, network := range networks {
+		desc, err := d.libvirt.NetworkGetXMLDesc(network, 0)
+		if err != nil {
+			return nil, err
+		}
+		s, err := parseNetworkSubnets(desc)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing network %s: %s", network.Name, err)
+		}
+		subnets = append(subnets, s...)
+	}
+	return subnets, nil
+}
+
+func (d *LibvirtDriver) CreateNetwork(xml string) (string, error) {
+	log.Printf("Creating network from XML\n%s", xml)
+	network, err := d.libvirt.NetworkCreateXML(xml)
+	if err != nil {
+		return "", err
+	}
+	return d.libvirt.NetworkGetBridgeName(network)
+}
+
+func (d *LibvirtDriver) DestroyNetwork(name string) error {
+	network, err := d.libvirt.NetworkLookupByName(name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Destroying network %s", name)
+	return d.libvirt.NetworkDestroy(network)
+}
+
+func (d *LibvirtDriver) NetworkDesc(name string) (string, error) {
+	network, err := d.libvirt.NetworkLookupByName(name)
+	if err != nil {
+		return "", err
+	}
+	return d.libvirt.NetworkGetXMLDesc(network, 0)
+}
+
+func (d *LibvirtDriver) NetworkLeases(name string) ([]string, error) {
+	network, err := d.libvirt.NetworkLookupByName(name)
+	if err != nil {
+		return nil, err
+	}
+	leases, _, err := d.libvirt.NetworkGetDhcpLeases(network, libvirt.OptString{}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, lease := range leases {
+		addrs = append(addrs, lease.Ipaddr)
+	}
+	return addrs, nil
+}
+
+func (d *LibvirtDriver) AddDHCPHost(network, hostXML string) error {
+	log.Printf("Adding DHCP host %s to network %s", hostXML, network)
+	return d.updateNetwork(network, libvirt.NetworkUpdateCommandAddLast, libvirt.NetworkSectionIPDhcpHost, hostXML)
+}
+
+func (d *LibvirtDriver) DeleteDHCPHost(network, hostXML string) error {
+	log.Printf("Deleting DHCP host %s from network %s", hostXML, network)
+	return d.updateNetwork(network, libvirt.NetworkUpdateCommandDelete, libvirt.NetworkSectionIPDhcpHost, hostXML)
+}
+
+// networkUpdateHasCorrectOrder is VIR_DRV_FEATURE_NETWORK_UPDATE_HAS_CORRECT_ORDER,
+// which is internal to libvirt.
+const networkUpdateHasCorrectOrder = 16
+
+// updateNetwork changes the running network. For a long time the libvirt
+// wire protocol had the command and section arguments of virNetworkUpdate
+// swapped, daemons with the fix say so with a feature flag.
+func (d *LibvirtDriver) updateNetwork(name string, command libvirt.NetworkUpdateCommand, section libvirt.NetworkUpdateSection, xml string) error {
+	network, err := d.libvirt.NetworkLookupByName(name)
+	if err != nil {
+		return err
+	}
+
+	correctOrder, err := d.libvirt.ConnectSupportsFeature(networkUpdateHasCorrectOrder)
+	if err != nil {
+		return err
+	}
+	if correctOrder == 1 {
+		return d.libvirt.NetworkUpdate(network, uint32(command), uint32(section), -1, xml, libvirt.NetworkUpdateAffectLive)
+	}
+	return d.libvirt.NetworkUpdate(network, uint32(section), uint32(command), -1, xml, libvirt.NetworkUpdateAffectLive)
+}
+
 func (d *LibvirtDriver) Verify() error {
 	networks, _, err := d.libvirt.ConnectListAllNetworks(1, libvirt.ConnectListNetworksActive)
 	if err != nil {
@@ -210,6 +896,17 @@ func (d *LibvirtDriver) Verify() error {
 	return fmt.Errorf("Not found available network for bridge %s", d.netBridge)
 }
 
+func (d *LibvirtDriver) DomainCapabilities(emulator, arch, machine, virtType string) (string, error) {
+	optString := func(s string) libvirt.OptString {
+		if s == "" {
+			return nil
+		}
+		return libvirt.OptString{s}
+	}
+	return d.libvirt.ConnectGetDomainCapabilities(
+		optString(emulator), optString(arch), optString(machine), optString(virtType), 0)
+}
+
 func (d *LibvirtDriver) Version() (string, error) {
 	version, err := d.libvirt.Version()
 	if err == nil {
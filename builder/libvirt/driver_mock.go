@@ -1,6 +1,11 @@
 package libvirt
 
-import "sync"
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+)
 
 type DriverMock struct {
 	sync.Mutex
@@ -11,16 +16,93 @@ type DriverMock struct {
 	StopCalled bool
 	StopErr    error
 
+	UndefineCalls []bool
+	UndefineErr   error
+
+	ShutdownCalls []string
+	ShutdownErr   error
+
 	LibvirtCalls [][]string
 	LibvirtErrs  []error
 
+	SendKeyCalls [][]uint32
+	SendKeyErr   error
+
+	OpenConsoleCalled bool
+	OpenConsoleOutput string
+	OpenConsoleErr    error
+
+	ScreenshotCalls  int
+	ScreenshotMime   string
+	ScreenshotOutput []byte
+	ScreenshotErr    error
+
+	AgentCommandCalls []string
+	AgentCommandFunc  func(command string) (string, error)
+
+	GetDomainIPCalls  [][]string
+	GetDomainIPResult string
+	GetDomainIPErr    error
+
 	WaitForShutdownCalled bool
 	WaitForShutdownState  bool
 
+	DomainEndedCh     chan struct{}
+	DomainEndErrorErr error
+
 	QemuImgCalled bool
 	QemuImgCalls  []string
 	QemuImgErrs   []error
 
+	CreateVolumeCalls   [][]string
+	CreateVolumeResults []string
+	CreateVolumeErr     error
+	CreateVolumeErrs    []error
+
+	LookupVolumeCalls    []string
+	LookupVolumePath     string
+	LookupVolumePaths    []string
+	LookupVolumeCapacity uint64
+	LookupVolumeErr      error
+
+	UploadVolumeCalls [][]string
+	UploadVolumeErr   error
+
+	DownloadVolumeCalls [][]string
+	DownloadVolumeErr   error
+
+	ResizeVolumeCalls []string
+	ResizeVolumeSizes []uint64
+	ResizeVolumeErr   error
+
+	DeleteVolumeCalls []string
+	DeleteVolumeErr   error
+
+	NetworkSubnetsResult []*net.IPNet
+	NetworkSubnetsErr    error
+
+	CreateNetworkCalls  []string
+	CreateNetworkBridge string
+	CreateNetworkErrs   []error
+
+	DestroyNetworkCalls []string
+	DestroyNetworkErr   error
+
+	NetworkDescResult string
+	NetworkDescErr    error
+
+	NetworkLeasesResult []string
+	NetworkLeasesErr    error
+
+	AddDHCPHostCalls    []string
+	AddDHCPHostErr      error
+	DeleteDHCPHostCalls []string
+	DeleteDHCPHostErr   error
+
+	DomainCapabilitiesCalls  [][]string
+	DomainCapabilitiesResult string
+	DomainCapabilitiesErr    error
+
 	VerifyCalled bool
 	VerifyErr    error
 
@@ -39,6 +121,19 @@ func (d *DriverMock) Stop() error {
 	return d.StopErr
 }
 
+func (d *DriverMock) Shutdown(method string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.ShutdownCalls = append(d.ShutdownCalls, method)
+	return d.ShutdownErr
+}
+
+func (d *DriverMock) Undefine(keepNVRAM bool) error {
+	d.UndefineCalls = append(d.UndefineCalls, keepNVRAM)
+	return d.UndefineErr
+}
+
 func (d *DriverMock) Start(args ...string) error {
 	d.LibvirtCalls = append(d.LibvirtCalls, args)
 
@@ -48,11 +143,60 @@ func (d *DriverMock) Start(args ...string) error {
 	return nil
 }
 
+func (d *DriverMock) SendKey(keycodes []uint32) error {
+	d.SendKeyCalls = append(d.SendKeyCalls, keycodes)
+	return d.SendKeyErr
+}
+
+func (d *DriverMock) OpenConsole(ctx context.Context, w io.Writer) error {
+	d.OpenConsoleCalled = true
+	if d.OpenConsoleOutput != "" {
+		w.Write([]byte(d.OpenConsoleOutput))
+	}
+	return d.OpenConsoleErr
+}
+
+func (d *DriverMock) Screenshot(w io.Writer) (string, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.ScreenshotCalls++
+	if d.ScreenshotErr != nil {
+		return "", d.ScreenshotErr
+	}
+	w.Write(d.ScreenshotOutput)
+	return d.ScreenshotMime, nil
+}
+
+func (d *DriverMock) AgentCommand(command string) (string, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.AgentCommandCalls = append(d.AgentCommandCalls, command)
+	if d.AgentCommandFunc != nil {
+		return d.AgentCommandFunc(command)
+	}
+	return `{"return":{}}`, nil
+}
+
+func (d *DriverMock) GetDomainIP(sources []string, mac string, family string) (string, error) {
+	d.GetDomainIPCalls = append(d.GetDomainIPCalls, append([]string{mac, family}, sources...))
+	return d.GetDomainIPResult, d.GetDomainIPErr
+}
+
 func (d *DriverMock) WaitForShutdown(cancelCh <-chan struct{}) bool {
 	d.WaitForShutdownCalled = true
 	return d.WaitForShutdownState
 }
 
+func (d *DriverMock) DomainEnded() <-chan struct{} {
+	return d.DomainEndedCh
+}
+
+func (d *DriverMock) DomainEndError() error {
+	return d.DomainEndErrorErr
+}
+
 func (d *DriverMock) QemuImg(args ...string) error {
 	d.QemuImgCalled = true
 	d.QemuImgCalls = append(d.QemuImgCalls, args...)
@@ -63,6 +207,93 @@ func (d *DriverMock) QemuImg(args ...string) error {
 	return nil
 }
 
+func (d *DriverMock) CreateVolume(pool, xml, source string) (string, error) {
+	d.CreateVolumeCalls = append(d.CreateVolumeCalls, []string{pool, xml, source})
+
+	path := ""
+	if len(d.CreateVolumeResults) >= len(d.CreateVolumeCalls) {
+		path = d.CreateVolumeResults[len(d.CreateVolumeCalls)-1]
+	}
+	err := d.CreateVolumeErr
+	if len(d.CreateVolumeErrs) >= len(d.CreateVolumeCalls) {
+		err = d.CreateVolumeErrs[len(d.CreateVolumeCalls)-1]
+	}
+	return path, err
+}
+
+func (d *DriverMock) LookupVolume(pool, name string) (string, uint64, error) {
+	d.LookupVolumeCalls = append(d.LookupVolumeCalls, name)
+
+	path := d.LookupVolumePath
+	if len(d.LookupVolumePaths) >= len(d.LookupVolumeCalls) {
+		path = d.LookupVolumePaths[len(d.LookupVolumeCalls)-1]
+	}
+	return path, d.LookupVolumeCapacity, d.LookupVolumeErr
+}
+
+func (d *DriverMock) UploadVolume(pool, name, path string) error {
+	d.UploadVolumeCalls = append(d.UploadVolumeCalls, []string{pool, name, path})
+	return d.UploadVolumeErr
+}
+
+func (d *DriverMock) DownloadVolume(pool, name, path string) error {
+	d.DownloadVolumeCalls = append(d.DownloadVolumeCalls, []string{pool, name, path})
+	return d.DownloadVolumeErr
+}
+
+func (d *DriverMock) ResizeVolume(pool, name string, size uint64) error {
+	d.ResizeVolumeCalls = append(d.ResizeVolumeCalls, name)
+	d.ResizeVolumeSizes = append(d.ResizeVolumeSizes, size)
+	return d.ResizeVolumeErr
+}
+
+func (d *DriverMock) DeleteVolume(pool, name string) error {
+	d.DeleteVolumeCalls = append(d.DeleteVolumeCalls, name)
+	return d.DeleteVolumeErr
+}
+
+func (d *DriverMock) NetworkSubnets() ([]*net.IPNet, error) {
+	return d.NetworkSubnetsResult, d.NetworkSubnetsErr
+}
+
+func (d *DriverMock) CreateNetwork(xml string) (string, error) {
+	d.CreateNetworkCalls = append(d.CreateNetworkCalls, xml)
+	if len(d.CreateNetworkErrs) >= len(d.CreateNetworkCalls) {
+		if err := d.CreateNetworkErrs[len(d.CreateNetworkCalls)-1]; err != nil {
+			return "", err
+		}
+	}
+	return d.CreateNetworkBridge, nil
+}
+
+func (d *DriverMock) DestroyNetwork(name string) error {
+	d.DestroyNetworkCalls = append(d.DestroyNetworkCalls, name)
+	return d.DestroyNetworkErr
+}
+
+func (d *DriverMock) NetworkDesc(name string) (string, error) {
+	return d.NetworkDescResult, d.NetworkDescErr
+}
+
+func (d *DriverMock) NetworkLeases(name string) ([]string, error) {
+	return d.NetworkLeasesResult, d.NetworkLeasesErr
+}
+
+func (d *DriverMock) AddDHCPHost(network, hostXML string) error {
+	d.AddDHCPHostCalls = append(d.AddDHCPHostCalls, hostXML)
+	return d.AddDHCPHostErr
+}
+
+func (d *DriverMock) DeleteDHCPHost(network, hostXML string) error {
+	d.DeleteDHCPHostCalls = append(d.DeleteDHCPHostCalls, hostXML)
+	return d.DeleteDHCPHostErr
+}
+
+func (d *DriverMock) DomainCapabilities(emulator, arch, machine, virtType string) (string, error) {
+	d.DomainCapabilitiesCalls = append(d.DomainCapabilitiesCalls, []string{emulator, arch, machine, virtType})
+	return d.DomainCapabilitiesResult, d.DomainCapabilitiesErr
+}
+
 func (d *DriverMock) Verify() error {
 	d.VerifyCalled = true
 	return d.VerifyErr
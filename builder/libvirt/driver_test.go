@@ -0,0 +1,51 @@
+package libvirt
+
+import (
+	"testing"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_lifecycleEventError(t *testing.T) {
+	domain := libvirt.Domain{Name: "packer-test"}
+	event := func(ev libvirt.DomainEventType, detail int32) libvirt.DomainEventLifecycleMsg {
+		return libvirt.DomainEventLifecycleMsg{Dom: domain, Event: int32(ev), Detail: detail}
+	}
+
+	assert.NoError(t, lifecycleEventError(domain, event(libvirt.DomainEventStarted, 0)))
+	assert.NoError(t, lifecycleEventError(domain, event(libvirt.DomainEventShutdown, 0)))
+	assert.EqualError(t,
+		lifecycleEventError(domain, event(libvirt.DomainEventStopped, int32(libvirt.DomainEventStoppedShutdown))),
+		"domain packer-test is shut off: shut down by the guest")
+	assert.EqualError(t,
+		lifecycleEventError(domain, event(libvirt.DomainEventStopped, int32(libvirt.DomainEventStoppedFailed))),
+		"domain packer-test is shut off: failed to start")
+	assert.EqualError(t,
+		lifecycleEventError(domain, event(libvirt.DomainEventCrashed, int32(libvirt.DomainEventCrashedPanicked))),
+		"domain packer-test is crashed: the guest panicked")
+}
+
+func Test_interfaceIPs(t *testing.T) {
+	ifaces := []libvirt.DomainInterface{
+		{Name: "lo", Hwaddr: []string{"00:00:00:00:00:00"}, Addrs: []libvirt.DomainIPAddr{{Addr: "127.0.0.1"}}},
+		{Name: "eth0", Hwaddr: []string{"52:54:00:aa:bb:cc"}, Addrs: []libvirt.DomainIPAddr{{Addr: "192.168.122.20"}}},
+		{Name: "eth1", Hwaddr: []string{"52:54:00:12:34:56"}, Addrs: []libvirt.DomainIPAddr{{Addr: "192.168.122.10"}}},
+	}
+
+	ips := interfaceIPs(ifaces, "52:54:00:12:34:56")
+	assert.Equal(t, "192.168.122.10", pickIP(ips, "ipv4").String())
+	assert.Len(t, ips, 1)
+
+	// An xml_file domain without the generated MAC address
+	ips = interfaceIPs(ifaces, "52:54:00:ff:ff:ff")
+	assert.Len(t, ips, 3)
+	assert.Equal(t, "192.168.122.20", pickIP(ips, "ipv4").String())
+}
+
+func Test_LibvirtDriver_StopNotStarted(t *testing.T) {
+	// A defined domain that failed to start has the ID -1, and there is no
+	// connection to destroy it with
+	d := &LibvirtDriver{vmDomain: libvirt.Domain{Name: "packer-test", ID: -1}, vmDefined: true}
+	assert.NoError(t, d.Stop())
+}
@@ -0,0 +1,320 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// guestAgentChunkSize is the number of bytes read or written with a single
+// guest-file-read or guest-file-write.
+const guestAgentChunkSize = 256 * 1024
+
+// guestAgentCommunicator runs commands and copies files through the QEMU
+// guest agent of the domain, so the guest doesn't need any network, only
+// qemu-guest-agent. Commands run with /bin/sh -c and their output is only
+// available once they exit, so it only works with POSIX guests, not with
+// Windows. DownloadDir isn't supported, so file provisioners can only
+// download single files. The exclude patterns of UploadDir match the path
+// relative to the directory or the file name.
+type guestAgentCommunicator struct {
+	driver Driver
+
+	// pollInterval is how often a running command is checked for its exit.
+	pollInterval time.Duration
+}
+
+var _ packersdk.Communicator = new(guestAgentCommunicator)
+
+type guestAgentRequest struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type guestAgentResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+type guestExecStatus struct {
+	Exited   bool   `json:"exited"`
+	ExitCode *int   `json:"exitcode"`
+	Signal   *int   `json:"signal"`
+	OutData  string `json:"out-data"`
+	ErrData  string `json:"err-data"`
+}
+
+type guestFileIO struct {
+	Count  int    `json:"count"`
+	BufB64 string `json:"buf-b64"`
+	EOF    bool   `json:"eof"`
+}
+
+// execute sends a guest agent command and decodes its return value into
+// result, when result is not nil.
+func (c *guestAgentCommunicator) execute(command string, args interface{}, result interface{}) error {
+	req, err := json.Marshal(guestAgentRequest{Execute: command, Arguments: args})
+	if err != nil {
+		return err
+	}
+	out, err := c.driver.AgentCommand(string(req))
+	if err != nil {
+		return fmt.Errorf("%s: %s", command, err)
+	}
+
+	var resp guestAgentResponse
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		return fmt.Errorf("%s: invalid response %q: %s", command, out, err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: %s", command, resp.Error.Desc)
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Return, result); err != nil {
+			return fmt.Errorf("%s: invalid response %q: %s", command, out, err)
+		}
+	}
+	return nil
+}
+
+// Ping checks that the guest agent is running.
+func (c *guestAgentCommunicator) Ping() error {
+	return c.execute("guest-ping", nil, nil)
+}
+
+func (c *guestAgentCommunicator) Start(ctx context.Context, cmd *packersdk.RemoteCmd) error {
+	args := map[string]interface{}{
+		"path":           "/bin/sh",
+		"arg":            []string{"-c", cmd.Command},
+		"capture-output": true,
+	}
+	if cmd.Stdin != nil {
+		input, err := ioutil.ReadAll(cmd.Stdin)
+		if err != nil {
+			return err
+		}
+		args["input-data"] = base64.StdEncoding.EncodeToString(input)
+	}
+
+	var started struct {
+		PID int `json:"pid"`
+	}
+	log.Printf("Executing command through the guest agent: %s", cmd.Command)
+	if err := c.execute("guest-exec", args, &started); err != nil {
+		return err
+	}
+
+	go func() {
+		status, err := c.waitExec(ctx, started.PID)
+		if err != nil {
+			log.Printf("Error waiting for guest agent command %d: %s", started.PID, err)
+			cmd.SetExited(packersdk.CmdDisconnect)
+			return
+		}
+
+		writeBase64(cmd.Stdout, status.OutData)
+		writeBase64(cmd.Stderr, status.ErrData)
+
+		exitCode := 0
+		if status.ExitCode != nil {
+			exitCode = *status.ExitCode
+		} else if status.Signal != nil {
+			exitCode = 128 + *status.Signal
+		}
+		cmd.SetExited(exitCode)
+	}()
+
+	return nil
+}
+
+// waitExec polls guest-exec-status until the command exits.
+func (c *guestAgentCommunicator) waitExec(ctx context.Context, pid int) (*guestExecStatus, error) {
+	for {
+		var status guestExecStatus
+		if err := c.execute("guest-exec-status", map[string]int{"pid": pid}, &status); err != nil {
+			return nil, err
+		}
+		if status.Exited {
+			return &status, nil
+		}
+
+		select {
+		case <-time.After(c.pollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// run executes a command and fails unless it exits with 0.
+func (c *guestAgentCommunicator) run(command string) error {
+	var stderr strings.Builder
+	cmd := &packersdk.RemoteCmd{Command: command, Stderr: &stderr}
+	if err := c.Start(context.TODO(), cmd); err != nil {
+		return err
+	}
+	if status := cmd.Wait(); status != 0 {
+		return fmt.Errorf("%q exited with %d: %s", command, status, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (c *guestAgentCommunicator) Upload(dst string, r io.Reader, fi *os.FileInfo) error {
+	var handle int
+	if err := c.execute("guest-file-open", map[string]string{"path": dst, "mode": "w"}, &handle); err != nil {
+		return err
+	}
+
+	buf := make([]byte, guestAgentChunkSize)
+	var err error
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			err = c.execute("guest-file-write", map[string]interface{}{
+				"handle":  handle,
+				"buf-b64": base64.StdEncoding.EncodeToString(buf[:n]),
+			}, nil)
+			if err != nil {
+				break
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			err = readErr
+			break
+		}
+	}
+
+	if closeErr := c.execute("guest-file-close", map[string]int{"handle": handle}, nil); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi != nil {
+		return c.run(fmt.Sprintf("chmod %o %s", (*fi).Mode().Perm(), shellQuote(dst)))
+	}
+	return nil
+}
+
+func (c *guestAgentCommunicator) UploadDir(dst string, src string, exclude []string) error {
+	// Same as the other communicators: without a trailing slash the
+	// directory itself is copied, with it only its contents.
+	if !strings.HasSuffix(src, "/") {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if excluded, err := isExcluded(rel, exclude); err != nil || excluded {
+			if err == nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		target := path.Join(dst, filepath.ToSlash(rel))
+
+		if info.IsDir() {
+			return c.run(fmt.Sprintf("mkdir -p %s", shellQuote(target)))
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return c.Upload(target, f, &info)
+	})
+}
+
+// isExcluded reports whether the path relative to the uploaded directory, or
+// its name, matches one of the exclude patterns.
+func isExcluded(rel string, exclude []string) (bool, error) {
+	if rel == "." {
+		return false, nil
+	}
+	for _, pattern := range exclude {
+		for _, name := range []string{filepath.ToSlash(rel), filepath.Base(rel)} {
+			matched, err := path.Match(pattern, name)
+			if err != nil {
+				return false, fmt.Errorf("invalid exclude pattern %q: %s", pattern, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (c *guestAgentCommunicator) Download(src string, w io.Writer) error {
+	var handle int
+	if err := c.execute("guest-file-open", map[string]string{"path": src, "mode": "r"}, &handle); err != nil {
+		return err
+	}
+
+	var err error
+	for {
+		var chunk guestFileIO
+		err = c.execute("guest-file-read", map[string]int{"handle": handle, "count": guestAgentChunkSize}, &chunk)
+		if err != nil {
+			break
+		}
+		if err = writeBase64(w, chunk.BufB64); err != nil {
+			break
+		}
+		if chunk.EOF || chunk.Count == 0 {
+			break
+		}
+	}
+
+	if closeErr := c.execute("guest-file-close", map[string]int{"handle": handle}, nil); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *guestAgentCommunicator) DownloadDir(src string, dst string, exclude []string) error {
+	return errors.New("DownloadDir is not supported by the guest-agent communicator")
+}
+
+// writeBase64 decodes data and writes it to w, unless w is nil.
+func writeBase64(w io.Writer, data string) error {
+	if w == nil || data == "" {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// shellQuote quotes s for /bin/sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
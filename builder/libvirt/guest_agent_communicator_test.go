@@ -0,0 +1,156 @@
+package libvirt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeGuestAgent answers guest agent commands from memory.
+type fakeGuestAgent struct {
+	files    map[string]*bytes.Buffer
+	handles  map[int]string
+	commands []string
+}
+
+func newFakeGuestAgent() *fakeGuestAgent {
+	return &fakeGuestAgent{files: map[string]*bytes.Buffer{}, handles: map[int]string{}}
+}
+
+func (a *fakeGuestAgent) command(command string) (string, error) {
+	var req struct {
+		Execute   string `json:"execute"`
+		Arguments struct {
+			Path   string   `json:"path"`
+			Mode   string   `json:"mode"`
+			Arg    []string `json:"arg"`
+			Handle int      `json:"handle"`
+			Count  int      `json:"count"`
+			BufB64 string   `json:"buf-b64"`
+		} `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(command), &req); err != nil {
+		return "", err
+	}
+	args := req.Arguments
+
+	switch req.Execute {
+	case "guest-ping":
+		return `{"return":{}}`, nil
+	case "guest-exec":
+		a.commands = append(a.commands, strings.Join(args.Arg, " "))
+		return `{"return":{"pid":42}}`, nil
+	case "guest-exec-status":
+		out := base64.StdEncoding.EncodeToString([]byte("hello\n"))
+		return fmt.Sprintf(`{"return":{"exited":true,"exitcode":3,"out-data":%q}}`, out), nil
+	case "guest-file-open":
+		if args.Mode == "w" {
+			a.files[args.Path] = new(bytes.Buffer)
+		} else if _, ok := a.files[args.Path]; !ok {
+			return `{"error":{"class":"GenericError","desc":"No such file"}}`, nil
+		}
+		handle := len(a.handles) + 1
+		a.handles[handle] = args.Path
+		return fmt.Sprintf(`{"return":%d}`, handle), nil
+	case "guest-file-write":
+		data, _ := base64.StdEncoding.DecodeString(args.BufB64)
+		a.files[a.handles[args.Handle]].Write(data)
+		return fmt.Sprintf(`{"return":{"count":%d,"eof":false}}`, len(data)), nil
+	case "guest-file-read":
+		data := a.files[a.handles[args.Handle]].Next(args.Count)
+		eof := a.files[a.handles[args.Handle]].Len() == 0
+		return fmt.Sprintf(`{"return":{"count":%d,"buf-b64":%q,"eof":%t}}`,
+			len(data), base64.StdEncoding.EncodeToString(data), eof), nil
+	case "guest-file-close":
+		return `{"return":{}}`, nil
+	}
+	return "", fmt.Errorf("unexpected command %s", req.Execute)
+}
+
+func Test_guestAgentCommunicator_Start(t *testing.T) {
+	agent := newFakeGuestAgent()
+	comm := &guestAgentCommunicator{
+		driver:       &DriverMock{AgentCommandFunc: agent.command},
+		pollInterval: time.Millisecond,
+	}
+
+	var stdout bytes.Buffer
+	cmd := &packersdk.RemoteCmd{Command: "echo hello; exit 3", Stdout: &stdout}
+	if err := comm.Start(context.TODO(), cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, 3, cmd.Wait())
+	assert.Equal(t, "hello\n", stdout.String())
+	assert.Equal(t, []string{"-c echo hello; exit 3"}, agent.commands)
+}
+
+func Test_guestAgentCommunicator_UploadDownload(t *testing.T) {
+	agent := newFakeGuestAgent()
+	comm := &guestAgentCommunicator{
+		driver:       &DriverMock{AgentCommandFunc: agent.command},
+		pollInterval: time.Millisecond,
+	}
+
+	data := bytes.Repeat([]byte("packer"), guestAgentChunkSize/2)
+	if err := comm.Upload("/tmp/script.sh", bytes.NewReader(data), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := comm.Download("/tmp/script.sh", &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, data, out.Bytes())
+
+	if err := comm.Download("/tmp/missing", &out); err == nil {
+		t.Fatal("should have error for a missing file")
+	}
+}
+
+func Test_StepConnectGuestAgent(t *testing.T) {
+	state := testState(t)
+	agent := newFakeGuestAgent()
+	state.Get("driver").(*DriverMock).AgentCommandFunc = agent.command
+
+	step := &stepConnectGuestAgent{Timeout: time.Minute}
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued")
+	}
+	if _, ok := state.Get("communicator").(*guestAgentCommunicator); !ok {
+		t.Fatalf("should have set the guest-agent communicator")
+	}
+}
+
+func Test_isExcluded(t *testing.T) {
+	exclude := []string{"*.log", "cache/*", ".git"}
+	for rel, excluded := range map[string]bool{
+		".":                false,
+		"setup.sh":         false,
+		"build.log":        true,
+		"sub/build.log":    true,
+		"cache/index":      true,
+		".git":             true,
+		"sub/.git":         true,
+		"sub/cache/index":  false,
+		"scripts/setup.sh": false,
+	} {
+		got, err := isExcluded(rel, exclude)
+		if err != nil {
+			t.Fatalf("%s: err: %s", rel, err)
+		}
+		assert.Equal(t, excluded, got, rel)
+	}
+
+	if _, err := isExcluded("setup.sh", []string{"["}); err == nil {
+		t.Fatal("invalid pattern should have error")
+	}
+}
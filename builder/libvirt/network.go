@@ -0,0 +1,279 @@
+package libvirt
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// networkDefinition is the part of a libvirt network definition the builder
+// reads and writes.
+type networkDefinition struct {
+	XMLName xml.Name       `xml:"network"`
+	Name    string         `xml:"name"`
+	Forward *networkMode   `xml:"forward"`
+	Bridge  *networkBridge `xml:"bridge"`
+	IPs     []networkIP    `xml:"ip"`
+}
+
+type networkMode struct {
+	Mode string `xml:"mode,attr"`
+}
+
+type networkBridge struct {
+	Name string `xml:"name,attr,omitempty"`
+	STP  string `xml:"stp,attr,omitempty"`
+}
+
+type networkIP struct {
+	Family  string       `xml:"family,attr,omitempty"`
+	Address string       `xml:"address,attr"`
+	Netmask string       `xml:"netmask,attr,omitempty"`
+	Prefix  int          `xml:"prefix,attr,omitempty"`
+	DHCP    *networkDHCP `xml:"dhcp"`
+}
+
+type networkDHCP struct {
+	Range networkDHCPRange  `xml:"range"`
+	Hosts []networkDHCPHost `xml:"host"`
+}
+
+type networkDHCPHost struct {
+	XMLName xml.Name `xml:"host"`
+	MAC     string   `xml:"mac,attr"`
+	IP      string   `xml:"ip,attr"`
+}
+
+type networkDHCPRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// parseNetworkSubnets returns the subnets of a libvirt network definition.
+func parseNetworkSubnets(desc string) ([]*net.IPNet, error) {
+	var def networkDefinition
+	if err := xml.Unmarshal([]byte(desc), &def); err != nil {
+		return nil, err
+	}
+
+	var subnets []*net.IPNet
+	for _, ip := range def.IPs {
+		addr := net.ParseIP(ip.Address)
+		if addr == nil {
+			continue
+		}
+		var mask net.IPMask
+		switch {
+		case ip.Netmask != "":
+			mask = net.IPMask(net.ParseIP(ip.Netmask).To4())
+		case ip.Prefix > 0 && addr.To4() != nil:
+			mask = net.CIDRMask(ip.Prefix, 32)
+		case ip.Prefix > 0:
+			mask = net.CIDRMask(ip.Prefix, 128)
+		default:
+			continue
+		}
+		subnets = append(subnets, &net.IPNet{IP: addr.Mask(mask), Mask: mask})
+	}
+	return subnets, nil
+}
+
+// networkGateway returns the address of the host on a libvirt network in the
+// ip_family, or nil when the network has none.
+func networkGateway(desc string, family string) (net.IP, error) {
+	var def networkDefinition
+	if err := xml.Unmarshal([]byte(desc), &def); err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, ip := range def.IPs {
+		if addr := net.ParseIP(ip.Address); addr != nil {
+			ips = append(ips, addr)
+		}
+	}
+	return pickIP(ips, family), nil
+}
+
+// freeSubnets returns the /24 subnets of pool that don't overlap any of the
+// used subnets, in order.
+func freeSubnets(pool *net.IPNet, used []*net.IPNet) []*net.IPNet {
+	ones, bits := pool.Mask.Size()
+	if bits != 32 || ones > 24 {
+		return nil
+	}
+
+	var free []*net.IPNet
+	start := binary.BigEndian.Uint32(pool.IP.To4())
+	for i := uint32(0); i < 1<<uint(24-ones); i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, start+i<<8)
+		subnet := &net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)}
+
+		overlaps := false
+		for _, u := range used {
+			if u.Contains(subnet.IP) || subnet.Contains(u.IP) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			free = append(free, subnet)
+		}
+	}
+	return free
+}
+
+// ephemeralNetworkXML renders a NAT network on the /24 subnet, with the
+// host on .1 and the rest of the subnet handed out by DHCP. libvirt picks
+// the name of the bridge.
+func ephemeralNetworkXML(name string, subnet *net.IPNet) (string, error) {
+	base := subnet.IP.To4()
+	host := net.IPv4(base[0], base[1], base[2], 1)
+
+	def := networkDefinition{
+		Name:    name,
+		Forward: &networkMode{Mode: "nat"},
+		Bridge:  &networkBridge{STP: "on"},
+		IPs: []networkIP{{
+			Address: host.String(),
+			Netmask: net.IP(subnet.Mask).String(),
+			DHCP: &networkDHCP{Range: networkDHCPRange{
+				Start: net.IPv4(base[0], base[1], base[2], 2).String(),
+				End:   net.IPv4(base[0], base[1], base[2], 254).String(),
+			}},
+		}},
+	}
+
+	out, err := xml.MarshalIndent(def, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// dhcpReservation describes the DHCP host reservation for the MAC address in
+// a libvirt network definition.
+type dhcpReservation struct {
+	// IP is the reserved address.
+	IP string
+	// Existing is set when the network already had a reservation for the
+	// MAC address.
+	Existing bool
+}
+
+// pickDHCPReservation returns the address to reserve for the MAC address
+// in the IPv4 DHCP range of the network, or nil when the network has no
+// DHCP. Addresses are picked from the end of the range, away from dynamic
+// leases, skipping reserved and leased addresses.
+func pickDHCPReservation(desc string, mac string, leased []string) (*dhcpReservation, error) {
+	var def networkDefinition
+	if err := xml.Unmarshal([]byte(desc), &def); err != nil {
+		return nil, err
+	}
+
+	for _, ip := range def.IPs {
+		if ip.DHCP == nil || net.ParseIP(ip.Address).To4() == nil {
+			continue
+		}
+
+		used := map[string]bool{ip.Address: true}
+		for _, host := range ip.DHCP.Hosts {
+			if strings.EqualFold(host.MAC, mac) && host.IP != "" {
+				return &dhcpReservation{IP: host.IP, Existing: true}, nil
+			}
+			used[host.IP] = true
+		}
+		for _, lease := range leased {
+			used[lease] = true
+		}
+
+		start := net.ParseIP(ip.DHCP.Range.Start).To4()
+		end := net.ParseIP(ip.DHCP.Range.End).To4()
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("invalid DHCP range %s - %s", ip.DHCP.Range.Start, ip.DHCP.Range.End)
+		}
+		for n := binary.BigEndian.Uint32(end); n >= binary.BigEndian.Uint32(start); n-- {
+			candidate := make(net.IP, 4)
+			binary.BigEndian.PutUint32(candidate, n)
+			if !used[candidate.String()] {
+				return &dhcpReservation{IP: candidate.String()}, nil
+			}
+		}
+		return nil, fmt.Errorf("no free address left in DHCP range %s - %s", ip.DHCP.Range.Start, ip.DHCP.Range.End)
+	}
+	return nil, nil
+}
+
+// pickIP returns the first address of ips in the ip_family, IPv4 first with
+// "any". Loopback addresses are skipped and link-local ones are only returned
+// when there's no other address, or nil when nothing matches.
+func pickIP(ips []net.IP, family string) net.IP {
+	var linkLocal net.IP
+	for _, want := range []string{"ipv4", "ipv6"} {
+		if family != "any" && family != want {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.IsLoopback() || (ip.To4() != nil) != (want == "ipv4") {
+				continue
+			}
+			if ip.IsLinkLocalUnicast() {
+				if linkLocal == nil {
+					linkLocal = ip
+				}
+				continue
+			}
+			return ip
+		}
+	}
+	return linkLocal
+}
+
+// matchesIPFamily returns whether the address belongs to the ip_family.
+func matchesIPFamily(ip net.IP, family string) bool {
+	switch family {
+	case "ipv4":
+		return ip.To4() != nil
+	case "ipv6":
+		return ip != nil && ip.To4() == nil
+	}
+	return ip != nil
+}
+
+// bracketIPv6 encloses IPv6 addresses in brackets, so a port can be appended
+// to them.
+func bracketIPv6(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// dhcpHostXML renders the DHCP host reservation used with NetworkUpdate.
+func dhcpHostXML(mac, ip string) (string, error) {
+	out, err := xml.Marshal(networkDHCPHost{MAC: mac, IP: ip})
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// randomMACAddress returns a random MAC address with the prefix QEMU uses.
+func randomMACAddress() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", b[0], b[1], b[2])
+}
+
+// ephemeralNetworkName returns a name for the ephemeral network of the VM
+// with a random suffix, so it never clashes with a network a crashed build
+// left behind.
+func ephemeralNetworkName(vmName string) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%x", vmName, b)
+}
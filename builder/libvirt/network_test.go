@@ -0,0 +1,137 @@
+package libvirt
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseNetworkSubnets(t *testing.T) {
+	subnets, err := parseNetworkSubnets(`<network>
+	<name>default</name>
+	<ip address='192.168.122.1' netmask='255.255.255.0'/>
+	<ip family='ipv4' address='10.0.8.1' prefix='22'/>
+	<ip family='ipv6' address='fd00::1' prefix='64'/>
+</network>`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual []string
+	for _, s := range subnets {
+		actual = append(actual, s.String())
+	}
+	assert.Equal(t, []string{"192.168.122.0/24", "10.0.8.0/22", "fd00::/64"}, actual)
+}
+
+func Test_freeSubnets(t *testing.T) {
+	_, pool, _ := net.ParseCIDR("10.213.0.0/22")
+	_, used1, _ := net.ParseCIDR("10.213.0.0/24")
+	_, used2, _ := net.ParseCIDR("10.213.2.128/25")
+
+	var actual []string
+	for _, s := range freeSubnets(pool, []*net.IPNet{used1, used2}) {
+		actual = append(actual, s.String())
+	}
+	assert.Equal(t, []string{"10.213.1.0/24", "10.213.3.0/24"}, actual)
+
+	_, small, _ := net.ParseCIDR("10.213.0.0/25")
+	assert.Empty(t, freeSubnets(small, nil))
+}
+
+func Test_ephemeralNetworkXML(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.213.4.0/24")
+	actual, err := ephemeralNetworkXML("packer-test", subnet)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := `<network>
+	<name>packer-test</name>
+	<forward mode="nat"></forward>
+	<bridge stp="on"></bridge>
+	<ip address="10.213.4.1" netmask="255.255.255.0">
+		<dhcp>
+			<range start="10.213.4.2" end="10.213.4.254"></range>
+		</dhcp>
+	</ip>
+</network>`
+	assert.Equal(t, expected, actual)
+
+	subnets, err := parseNetworkSubnets(actual)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, []*net.IPNet{subnet}, subnets)
+}
+
+func Test_pickDHCPReservation(t *testing.T) {
+	desc := `<network>
+	<name>default</name>
+	<ip address='192.168.122.1' netmask='255.255.255.0'>
+		<dhcp>
+			<range start='192.168.122.2' end='192.168.122.254'/>
+			<host mac='52:54:00:aa:bb:cc' ip='192.168.122.254'/>
+		</dhcp>
+	</ip>
+</network>`
+
+	r, err := pickDHCPReservation(desc, "52:54:00:12:34:56", []string{"192.168.122.253"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, &dhcpReservation{IP: "192.168.122.252"}, r)
+
+	r, err = pickDHCPReservation(desc, "52:54:00:AA:BB:CC", nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, &dhcpReservation{IP: "192.168.122.254", Existing: true}, r)
+
+	r, err = pickDHCPReservation(`<network><ip address='10.0.0.1' prefix='24'/></network>`, "52:54:00:12:34:56", nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Nil(t, r)
+
+	full := `<network><ip address='10.0.0.1' prefix='24'><dhcp><range start='10.0.0.2' end='10.0.0.3'/></dhcp></ip></network>`
+	if _, err := pickDHCPReservation(full, "52:54:00:12:34:56", []string{"10.0.0.2", "10.0.0.3"}); err == nil {
+		t.Fatal("should have error for a full DHCP range")
+	}
+}
+
+func Test_dhcpHostXML(t *testing.T) {
+	actual, err := dhcpHostXML("52:54:00:12:34:56", "192.168.122.254")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, `<host mac="52:54:00:12:34:56" ip="192.168.122.254"></host>`, actual)
+}
+
+func Test_randomMACAddress(t *testing.T) {
+	mac, err := net.ParseMAC(randomMACAddress())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "52:54:00", mac.String()[:8])
+}
+
+func Test_pickIP(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("127.0.0.1"),
+		net.ParseIP("fe80::1"),
+		net.ParseIP("fd00::1"),
+		net.ParseIP("192.168.122.1"),
+	}
+	assert.Equal(t, "192.168.122.1", pickIP(ips, "ipv4").String())
+	assert.Equal(t, "fd00::1", pickIP(ips, "ipv6").String())
+	assert.Equal(t, "192.168.122.1", pickIP(ips, "any").String())
+	assert.Equal(t, "fe80::1", pickIP(ips[:2], "any").String())
+	assert.Nil(t, pickIP(ips[:2], "ipv4"))
+}
+
+func Test_bracketIPv6(t *testing.T) {
+	assert.Equal(t, "192.168.122.1", bracketIPv6("192.168.122.1"))
+	assert.Equal(t, "[fd00::1]", bracketIPv6("fd00::1"))
+	assert.Equal(t, "[fe80::1%virbr0]", bracketIPv6("fe80::1%virbr0"))
+}
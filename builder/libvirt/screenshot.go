@@ -0,0 +1,148 @@
+package libvirt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// screenshotDir is the directory below the output directory the screenshots
+// are saved to. It is kept when the build fails.
+const screenshotDir = "screenshots"
+
+// screenshotter saves numbered screenshots of the domain, so that they sort
+// in the order they were taken.
+type screenshotter struct {
+	driver Driver
+	dir    string
+	prefix string
+
+	mu sync.Mutex
+	n  int
+}
+
+func newScreenshotter(driver Driver, config *Config) *screenshotter {
+	return &screenshotter{
+		driver: driver,
+		dir:    filepath.Join(config.OutputDir, screenshotDir),
+		prefix: config.VMName,
+	}
+}
+
+// Take saves a screenshot named after label and returns its path. Images
+// libvirt returns as PPM, as QEMU does, are converted to PNG.
+func (s *screenshotter) Take(label string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	mime, err := s.driver.Screenshot(&buf)
+	if err != nil {
+		return "", fmt.Errorf("Error taking screenshot: %s", err)
+	}
+
+	data, ext := buf.Bytes(), ".png"
+	switch mime {
+	case "image/png":
+	case "image/x-portable-pixmap":
+		img, err := decodePPM(&buf)
+		if err != nil {
+			return "", fmt.Errorf("Error decoding screenshot: %s", err)
+		}
+		var out bytes.Buffer
+		if err := png.Encode(&out, img); err != nil {
+			return "", fmt.Errorf("Error encoding screenshot: %s", err)
+		}
+		data = out.Bytes()
+	default:
+		// Keep whatever we got, it's still better than nothing
+		ext = ".img"
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", err
+	}
+	s.n++
+	path := filepath.Join(s.dir, fmt.Sprintf("%s-%03d-%s%s", s.prefix, s.n, label, ext))
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// decodePPM decodes a binary (P6) portable pixmap.
+func decodePPM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := ppmToken(br)
+	if err != nil {
+		return nil, err
+	}
+	if magic != "P6" {
+		return nil, fmt.Errorf("unsupported PPM format %q", magic)
+	}
+	var header [3]int
+	for i := range header {
+		token, err := ppmToken(br)
+		if err != nil {
+			return nil, err
+		}
+		header[i], err = strconv.Atoi(token)
+		if err != nil || header[i] <= 0 {
+			return nil, fmt.Errorf("invalid PPM header value %q", token)
+		}
+	}
+	width, height, maxval := header[0], header[1], header[2]
+	if maxval > 255 {
+		return nil, fmt.Errorf("unsupported PPM maxval %d", maxval)
+	}
+
+	pixels := make([]byte, width*height*3)
+	if _, err := io.ReadFull(br, pixels); err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		img.Set(i%width, i/width, color.RGBA{
+			R: uint8(int(pixels[i*3]) * 255 / maxval),
+			G: uint8(int(pixels[i*3+1]) * 255 / maxval),
+			B: uint8(int(pixels[i*3+2]) * 255 / maxval),
+			A: 255,
+		})
+	}
+	return img, nil
+}
+
+// ppmToken reads the next header token, skipping whitespace and comments,
+// and consumes the single whitespace byte that ends it.
+func ppmToken(br *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '#' && len(token) == 0:
+			if _, err := br.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, c)
+		}
+	}
+}
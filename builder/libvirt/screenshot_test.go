@@ -0,0 +1,90 @@
+package libvirt
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_decodePPM(t *testing.T) {
+	data := append([]byte("P6\n# qemu\n2 1\n255\n"), 255, 0, 0, 0, 0, 255)
+	img, err := decodePPM(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, 2, img.Bounds().Dx())
+	assert.Equal(t, 1, img.Bounds().Dy())
+	r, _, b, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+	assert.Equal(t, uint32(0), b)
+	r, _, b, _ = img.At(1, 0).RGBA()
+	assert.Equal(t, uint32(0), r)
+	assert.Equal(t, uint32(0xffff), b)
+
+	for _, bad := range []string{"P3\n1 1\n255\n", "P6\n1 1\n65535\n", "P6\n2 2\n255\n\x00"} {
+		if _, err := decodePPM(bytes.NewReader([]byte(bad))); err == nil {
+			t.Errorf("%q: should have error", bad)
+		}
+	}
+}
+
+func Test_StepScreenshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := runTestConfig()
+	config.OutputDir = dir
+	state := runTestState(t, config)
+	d := state.Get("driver").(*DriverMock)
+	d.ScreenshotMime = "image/x-portable-pixmap"
+	d.ScreenshotOutput = append([]byte("P6 1 1 255\n"), 1, 2, 3)
+
+	step := new(stepScreenshot)
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued")
+	}
+
+	path, err := state.Get("screenshotter").(*screenshotter).Take("boot-command")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, filepath.Join(dir, "screenshots", "packer-test-001-boot-command.png"), path)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer f.Close()
+	if _, err := png.Decode(f); err != nil {
+		t.Fatalf("should have saved a PNG: %s", err)
+	}
+
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	assert.Equal(t, 2, d.ScreenshotCalls)
+	if _, err := os.Stat(filepath.Join(dir, "screenshots", "packer-test-002-failure.png")); err != nil {
+		t.Fatalf("should have saved a failure screenshot: %s", err)
+	}
+}
+
+func Test_StepScreenshot_Success(t *testing.T) {
+	state := runTestState(t, runTestConfig())
+
+	step := new(stepScreenshot)
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued")
+	}
+	step.Cleanup(state)
+
+	d := state.Get("driver").(*DriverMock)
+	assert.Equal(t, 0, d.ScreenshotCalls)
+}
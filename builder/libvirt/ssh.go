@@ -1,21 +1,44 @@
 package libvirt
 
 import (
+	"fmt"
 	"log"
+	"net"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 )
 
-func commHost(host string) func(multistep.StateBag) (string, error) {
+func commHost(host string, sources []string, mac string, family string) func(multistep.StateBag) (string, error) {
 	return func(state multistep.StateBag) (string, error) {
 		if host != "" {
 			log.Printf("Using host value: %s", host)
 			return host, nil
 		}
 
-		driver := state.Get("driver").(*LibvirtDriver)
+		// The MAC address of an xml_file domain may not be the one the
+		// address was reserved for
+		config := state.Get("config").(*Config)
+		guestIP, ok := state.GetOk("guest_ip")
+		if ok && config.XMLFile == "" && matchesIPFamily(net.ParseIP(guestIP.(string)), family) {
+			log.Printf("Using reserved address: %s", guestIP)
+			return guestIP.(string), nil
+		}
+
+		driver := state.Get("driver").(Driver)
 
-		return driver.GetDomainIP()
+		ip, err := driver.GetDomainIP(sources, mac, family)
+		if err != nil {
+			return "", err
+		}
+		// Link-local addresses are only reachable through the bridge, which
+		// only exists on the hypervisor
+		if parsed := net.ParseIP(ip); parsed.To4() == nil && parsed.IsLinkLocalUnicast() {
+			if u, err := parseLibvirtURI(config.LibvirtAddr); err != nil || !u.isLocal() {
+				return "", fmt.Errorf("The VM only has the link-local address %s, which can't be reached when libvirt runs on another host", ip)
+			}
+			ip += "%" + hostBridge(state)
+		}
+		return bracketIPv6(ip), nil
 	}
 }
 
@@ -0,0 +1,81 @@
+package libvirt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_commHost(t *testing.T) {
+	state := testState(t)
+	state.Put("config", &Config{})
+	d := state.Get("driver").(*DriverMock)
+	d.GetDomainIPResult = "192.168.122.10"
+
+	host, err := commHost("", []string{"lease", "agent"}, "52:54:00:12:34:56", "ipv4")(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "192.168.122.10", host)
+	assert.Equal(t, [][]string{{"52:54:00:12:34:56", "ipv4", "lease", "agent"}}, d.GetDomainIPCalls)
+
+	d.GetDomainIPErr = errors.New("no ipv4 address")
+	if _, err := commHost("", []string{"lease"}, "52:54:00:12:34:56", "ipv4")(state); err == nil {
+		t.Fatal("should have error")
+	}
+
+	host, err = commHost("10.0.0.5", []string{"lease"}, "52:54:00:12:34:56", "ipv4")(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "10.0.0.5", host)
+	assert.Len(t, d.GetDomainIPCalls, 2)
+
+	state.Put("guest_ip", "192.168.122.254")
+	host, err = commHost("", []string{"lease"}, "52:54:00:12:34:56", "ipv4")(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "192.168.122.254", host)
+	assert.Len(t, d.GetDomainIPCalls, 2)
+
+	// An xml_file domain may not use the MAC address the address was
+	// reserved for
+	state.Put("config", &Config{XMLFile: "testdata/xml-file.tmpl"})
+	d.GetDomainIPErr = nil
+	host, err = commHost("", []string{"lease"}, "52:54:00:12:34:56", "ipv4")(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "192.168.122.10", host)
+	assert.Len(t, d.GetDomainIPCalls, 3)
+
+	// The IPv4 reservation doesn't match ip_family ipv6
+	config := &Config{
+		LibvirtAddr:       "qemu:///system",
+		NetBridge:         "virbr0",
+		NetworkInterfaces: []NetworkInterface{{Communicator: true}},
+	}
+	state.Put("config", config)
+	d.GetDomainIPErr = nil
+	d.GetDomainIPResult = "fd00::10"
+	host, err = commHost("", []string{"lease"}, "52:54:00:12:34:56", "ipv6")(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "[fd00::10]", host)
+
+	d.GetDomainIPResult = "fe80::5054:ff:fe12:3456"
+	host, err = commHost("", []string{"agent"}, "52:54:00:12:34:56", "ipv6")(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "[fe80::5054:ff:fe12:3456%virbr0]", host)
+
+	// virbr0 isn't a zone on this host with a remote hypervisor
+	config.LibvirtAddr = "qemu+ssh://root@hv1/system"
+	if _, err := commHost("", []string{"agent"}, "52:54:00:12:34:56", "ipv6")(state); err == nil {
+		t.Fatal("link-local address of a remote VM should have error")
+	}
+}
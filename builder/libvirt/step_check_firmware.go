@@ -0,0 +1,113 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// domainCapabilities is the part of the libvirt domain capabilities the
+// builder reads.
+type domainCapabilities struct {
+	OS struct {
+		Enums  []domainCapabilitiesEnum `xml:"enum"`
+		Loader struct {
+			Enums []domainCapabilitiesEnum `xml:"enum"`
+		} `xml:"loader"`
+	} `xml:"os"`
+}
+
+type domainCapabilitiesEnum struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"value"`
+}
+
+// enumValues returns the values of the named enum, and whether the enum
+// is listed at all.
+func enumValues(enums []domainCapabilitiesEnum, name string) ([]string, bool) {
+	for _, enum := range enums {
+		if enum.Name == name {
+			return enum.Values, true
+		}
+	}
+	return nil, false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFirmware returns why the domain capabilities don't allow the
+// firmware and Secure Boot setting of the config, or nil.
+func checkFirmware(caps string, config *Config) error {
+	var def domainCapabilities
+	if err := xml.Unmarshal([]byte(caps), &def); err != nil {
+		return err
+	}
+
+	firmwares, ok := enumValues(def.OS.Enums, "firmware")
+	if !ok {
+		return fmt.Errorf("libvirt doesn't support firmware auto-selection")
+	}
+	if !containsString(firmwares, config.Firmware) {
+		return fmt.Errorf("firmware %q isn't available, only %q", config.Firmware, firmwares)
+	}
+
+	if config.SecureBoot {
+		secure, _ := enumValues(def.OS.Loader.Enums, "secure")
+		if !containsString(secure, "yes") {
+			return fmt.Errorf("no Secure Boot firmware is available")
+		}
+	}
+	return nil
+}
+
+// This step checks the firmware requested by firmware and secure_boot
+// against the domain capabilities of the hypervisor, before anything is
+// downloaded or created. This can't be done in Config.Prepare, which has no
+// libvirt connection; the settings that don't depend on the hypervisor, like
+// the q35 machine type of secure_boot, are checked there.
+//
+// Uses:
+//   config *config
+//   driver Driver
+//   ui     packersdk.Ui
+//
+// Produces:
+//   <nothing>
+type stepCheckFirmware struct{}
+
+func (s *stepCheckFirmware) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	if config.Firmware == "" {
+		return multistep.ActionContinue
+	}
+
+	caps, err := driver.DomainCapabilities(config.EmulatorBinary, config.Arch, config.MachineType, config.Hypervisor)
+	if err == nil {
+		log.Printf("Domain capabilities:\n%s", caps)
+		err = checkFirmware(caps, config)
+	}
+	if err != nil {
+		err := fmt.Errorf("Error checking firmware %s of machine type %s: %s", config.Firmware, config.MachineType, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepCheckFirmware) Cleanup(multistep.StateBag) {}
@@ -0,0 +1,64 @@
+package libvirt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDomainCapabilities = `<domainCapabilities>
+	<path>/usr/bin/qemu-system-x86_64</path>
+	<domain>kvm</domain>
+	<machine>pc-q35-6.2</machine>
+	<arch>x86_64</arch>
+	<os supported='yes'>
+		<enum name='firmware'>
+			<value>bios</value>
+			<value>efi</value>
+		</enum>
+		<loader supported='yes'>
+			<value>/usr/share/OVMF/OVMF_CODE_4M.fd</value>
+			<enum name='type'>
+				<value>rom</value>
+				<value>pflash</value>
+			</enum>
+			<enum name='secure'>
+				<value>no</value>
+			</enum>
+		</loader>
+	</os>
+</domainCapabilities>`
+
+func Test_checkFirmware(t *testing.T) {
+	assert.NoError(t, checkFirmware(testDomainCapabilities, &Config{Firmware: "efi"}))
+	assert.NoError(t, checkFirmware(testDomainCapabilities, &Config{Firmware: "bios"}))
+	assert.Error(t, checkFirmware(testDomainCapabilities, &Config{Firmware: "efi", SecureBoot: true}))
+	assert.Error(t, checkFirmware(`<domainCapabilities><os supported='yes'/></domainCapabilities>`, &Config{Firmware: "efi"}))
+}
+
+func Test_StepCheckFirmware(t *testing.T) {
+	config := runTestConfig()
+	state := runTestState(t, config)
+	d := state.Get("driver").(*DriverMock)
+	d.DomainCapabilitiesResult = testDomainCapabilities
+
+	step := new(stepCheckFirmware)
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued: %v", state.Get("error"))
+	}
+	assert.Empty(t, d.DomainCapabilitiesCalls)
+
+	config.Firmware = "efi"
+	config.MachineType = "q35"
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued: %v", state.Get("error"))
+	}
+	assert.Equal(t, [][]string{{"/usr/libexec/qemu-kvm", "x86_64", "q35", "kvm"}}, d.DomainCapabilitiesCalls)
+
+	config.SecureBoot = true
+	if action := step.Run(context.TODO(), state); action != multistep.ActionHalt {
+		t.Fatal("Should have halted")
+	}
+}
@@ -0,0 +1,66 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// This step waits for the QEMU guest agent to answer and sets up the
+// guest-agent communicator. It replaces communicator.StepConnect when
+// communicator is "guest-agent".
+//
+// Uses:
+//   driver Driver
+//   ui     packersdk.Ui
+//
+// Produces:
+//   communicator packersdk.Communicator
+type stepConnectGuestAgent struct {
+	Timeout time.Duration
+}
+
+// guestAgentRetryInterval is how often the guest agent is pinged until it
+// answers.
+var guestAgentRetryInterval = 5 * time.Second
+
+func (s *stepConnectGuestAgent) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	comm := &guestAgentCommunicator{
+		driver:       driver,
+		pollInterval: time.Second,
+	}
+
+	ui.Say("Waiting for the QEMU guest agent to become available...")
+	timeout := time.After(s.Timeout)
+	for {
+		err := comm.Ping()
+		if err == nil {
+			break
+		}
+		log.Printf("Guest agent not available yet: %s", err)
+
+		select {
+		case <-time.After(guestAgentRetryInterval):
+		case <-timeout:
+			err := fmt.Errorf("Timeout waiting for the QEMU guest agent: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		case <-ctx.Done():
+			return multistep.ActionHalt
+		}
+	}
+
+	ui.Say("Connected to the QEMU guest agent!")
+	state.Put("communicator", comm)
+	return multistep.ActionContinue
+}
+
+func (s *stepConnectGuestAgent) Cleanup(multistep.StateBag) {}
@@ -22,6 +22,8 @@ type stepConvertDisk struct {
 	OutputDir       string
 	SkipCompaction  bool
 	VMName          string
+	StoragePool     string
+	UseBackingFile  bool
 
 	QemuImgArgs QemuImgArgs
 }
@@ -32,6 +34,10 @@ func (s *stepConvertDisk) Run(ctx context.Context, state multistep.StateBag) mul
 
 	diskName := s.VMName
 
+	if s.StoragePool != "" {
+		return s.downloadVolumes(state)
+	}
+
 	if s.SkipCompaction && !s.DiskCompression {
 		return multistep.ActionContinue
 	}
@@ -101,4 +107,49 @@ func (s *stepConvertDisk) buildConvertCommand(sourcePath, targetPath string) []s
 	return command
 }
 
+// downloadVolumes streams the disks out of the storage pool into the output
+// directory. The 'main' disk is first cloned through libvirt, which compacts
+// it and flattens any backing chain that only exists on the hypervisor.
+func (s *stepConvertDisk) downloadVolumes(state multistep.StateBag) multistep.StepAction {
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+	diskPaths := state.Get("qemu_disk_paths").([]string)
+
+	for i, diskPath := range diskPaths {
+		name := filepath.Base(diskPath)
+		source := name
+
+		if i == 0 && (!s.SkipCompaction || s.UseBackingFile) {
+			ui.Say("Converting hard drive...")
+			source = name + ".convert"
+			xml, err := volumeXML(source, s.Format, 0, "", "")
+			if err == nil {
+				_, err = driver.CreateVolume(s.StoragePool, xml, name)
+			}
+			if err != nil {
+				err := fmt.Errorf("Error converting hard drive: %s", err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+		}
+
+		ui.Say(fmt.Sprintf("Downloading %s from storage pool %s...", name, s.StoragePool))
+		err := driver.DownloadVolume(s.StoragePool, source, diskPath)
+		if source != name {
+			if err := driver.DeleteVolume(s.StoragePool, source); err != nil {
+				ui.Error(fmt.Sprintf("Error deleting volume %s: %s", source, err))
+			}
+		}
+		if err != nil {
+			err := fmt.Errorf("Error downloading hard drive: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
 func (s *stepConvertDisk) Cleanup(state multistep.StateBag) {}
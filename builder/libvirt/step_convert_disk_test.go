@@ -1,9 +1,11 @@
 package libvirt
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -50,3 +52,31 @@ func Test_buildConvertCommand(t *testing.T) {
 			fmt.Sprintf("%s. Expected %#v", tc.Reason, tc.Expected))
 	}
 }
+
+func Test_StepConvertDisk_StoragePool(t *testing.T) {
+	step := &stepConvertDisk{
+		Format:      "qcow2",
+		VMName:      "target",
+		StoragePool: "default",
+	}
+
+	d := new(DriverMock)
+	state := testState(t)
+	state.Put("driver", d)
+	state.Put("qemu_disk_paths", []string{"output/target", "output/target-1"})
+	action := step.Run(context.TODO(), state)
+	if action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+
+	if d.QemuImgCalled {
+		t.Fatalf("Should not have called qemu-img when storage_pool is set")
+	}
+	assert.Len(t, d.CreateVolumeCalls, 1)
+	assert.Equal(t, "target", d.CreateVolumeCalls[0][2], "Should compact by cloning the main disk")
+	assert.Equal(t, [][]string{
+		{"default", "target.convert", "output/target"},
+		{"default", "target-1", "output/target-1"},
+	}, d.DownloadVolumeCalls)
+	assert.Equal(t, []string{"target.convert"}, d.DeleteVolumeCalls)
+}
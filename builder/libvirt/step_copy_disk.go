@@ -3,6 +3,8 @@ package libvirt
 import (
 	"context"
 	"fmt"
+	"log"
+	"os"
 	"path/filepath"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
@@ -17,8 +19,11 @@ type stepCopyDisk struct {
 	OutputDir      string
 	UseBackingFile bool
 	VMName         string
+	StoragePool    string
 
 	QemuImgArgs QemuImgArgs
+
+	volumes []string
 }
 
 func (s *stepCopyDisk) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -34,6 +39,17 @@ func (s *stepCopyDisk) Run(ctx context.Context, state multistep.StateBag) multis
 		return multistep.ActionContinue
 	}
 
+	if s.StoragePool != "" {
+		ui.Say("Copying hard drive into storage pool...")
+		if err := s.copyVolume(driver, isoPath, state); err != nil {
+			err := fmt.Errorf("Error creating hard drive: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		return multistep.ActionContinue
+	}
+
 	// In some cases, the file formats provided are equivalent by comparing the
 	// file extensions. Skip the conversion step
 	// This also serves as a workaround for a QEMU bug: https://bugs.launchpad.net/qemu/+bug/1776920
@@ -75,4 +91,82 @@ func (s *stepCopyDisk) buildConvertCommand(sourcePath, targetPath string) []stri
 	return command
 }
 
-func (s *stepCopyDisk) Cleanup(state multistep.StateBag) {}
+// copyVolume uploads the disk image into the storage pool and clones it into
+// the 'main' disk, converting it to the requested format on the way.
+func (s *stepCopyDisk) copyVolume(driver Driver, isoPath string, state multistep.StateBag) error {
+	sourceName := sourceVolumeName(s.VMName)
+	if _, _, err := uploadSourceVolume(driver, s.StoragePool, sourceName, isoPath); err != nil {
+		return err
+	}
+	defer func() {
+		if err := driver.DeleteVolume(s.StoragePool, sourceName); err != nil {
+			log.Printf("Error deleting volume %s: %s", sourceName, err)
+		}
+	}()
+
+	xml, err := volumeXML(s.VMName, s.Format, 0, "", "")
+	if err != nil {
+		return err
+	}
+	path, err := driver.CreateVolume(s.StoragePool, xml, sourceName)
+	if err != nil {
+		return err
+	}
+	s.volumes = append(s.volumes, s.VMName)
+
+	volumePaths := state.Get("volume_paths").([]string)
+	volumePaths[0] = path
+	return nil
+}
+
+// sourceVolumeName is the volume holding the uploaded disk_image source.
+func sourceVolumeName(vmName string) string {
+	return vmName + "-source"
+}
+
+// uploadSourceVolume creates a volume sized for the local image and uploads
+// the image into it. It returns the path and format of the new volume.
+func uploadSourceVolume(driver Driver, pool, name, isoPath string) (string, string, error) {
+	info, err := os.Stat(isoPath)
+	if err != nil {
+		return "", "", err
+	}
+	format, err := detectImageFormat(isoPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	xml, err := volumeXML(name, format, uint64(info.Size()), "", "")
+	if err != nil {
+		return "", "", err
+	}
+	path, err := driver.CreateVolume(pool, xml, "")
+	if err != nil {
+		return "", "", err
+	}
+	if err := driver.UploadVolume(pool, name, isoPath); err != nil {
+		driver.DeleteVolume(pool, name)
+		return "", "", err
+	}
+
+	return path, format, nil
+}
+
+// deleteVolumes removes the given volumes from the storage pool, newest first.
+func deleteVolumes(state multistep.StateBag, pool string, volumes []string) {
+	if len(volumes) == 0 {
+		return
+	}
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	for i := len(volumes) - 1; i >= 0; i-- {
+		if err := driver.DeleteVolume(pool, volumes[i]); err != nil {
+			ui.Error(fmt.Sprintf("Error deleting volume %s: %s", volumes[i], err))
+		}
+	}
+}
+
+func (s *stepCopyDisk) Cleanup(state multistep.StateBag) {
+	deleteVolumes(state, s.StoragePool, s.volumes)
+}
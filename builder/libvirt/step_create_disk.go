@@ -21,6 +21,9 @@ type stepCreateDisk struct {
 	UseBackingFile     bool
 	VMName             string
 	QemuImgArgs        QemuImgArgs
+	StoragePool        string
+
+	volumes []string
 }
 
 func (s *stepCreateDisk) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -46,6 +49,7 @@ func (s *stepCreateDisk) Run(ctx context.Context, state multistep.StateBag) mult
 	}
 
 	// Create all required disks
+	volumePaths := make([]string, len(diskFullPaths))
 	for i, diskFullPath := range diskFullPaths {
 		if s.DiskImage && !s.UseBackingFile && i == 0 {
 			// Let the copy disk step (step_copy_disk.go) create the 'main' or
@@ -54,6 +58,18 @@ func (s *stepCreateDisk) Run(ctx context.Context, state multistep.StateBag) mult
 		}
 		log.Printf("[INFO] Creating disk with Path: %s and Size: %s", diskFullPath, diskSizes[i])
 
+		if s.StoragePool != "" {
+			path, err := s.createVolume(driver, filepath.Base(diskFullPath), diskSizes[i], i, state)
+			if err != nil {
+				err := fmt.Errorf("Error creating hard drive: %s", err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+			volumePaths[i] = path
+			continue
+		}
+
 		command := s.buildCreateCommand(diskFullPath, diskSizes[i], i, state)
 
 		if err := driver.QemuImg(command...); err != nil {
@@ -66,6 +82,9 @@ func (s *stepCreateDisk) Run(ctx context.Context, state multistep.StateBag) mult
 
 	// Stash the disk paths so we can retrieve later
 	state.Put("qemu_disk_paths", diskFullPaths)
+	if s.StoragePool != "" {
+		state.Put("volume_paths", volumePaths)
+	}
 
 	return multistep.ActionContinue
 }
@@ -88,4 +107,38 @@ func (s *stepCreateDisk) buildCreateCommand(path string, size string, i int, sta
 	return command
 }
 
-func (s *stepCreateDisk) Cleanup(state multistep.StateBag) {}
+// createVolume creates the disk as a volume in the storage pool, on top of
+// the uploaded disk image when a backing file is requested.
+func (s *stepCreateDisk) createVolume(driver Driver, name string, size string, i int, state multistep.StateBag) (string, error) {
+	capacity, err := diskSizeBytes(size)
+	if err != nil {
+		return "", err
+	}
+
+	backingPath, backingFormat := "", ""
+	if s.DiskImage && s.UseBackingFile && i == 0 {
+		isoPath := state.Get("iso_path").(string)
+		sourceName := sourceVolumeName(s.VMName)
+		backingPath, backingFormat, err = uploadSourceVolume(driver, s.StoragePool, sourceName, isoPath)
+		if err != nil {
+			return "", err
+		}
+		s.volumes = append(s.volumes, sourceName)
+	}
+
+	xml, err := volumeXML(name, s.Format, capacity, backingPath, backingFormat)
+	if err != nil {
+		return "", err
+	}
+	path, err := driver.CreateVolume(s.StoragePool, xml, "")
+	if err != nil {
+		return "", err
+	}
+	s.volumes = append(s.volumes, name)
+
+	return path, nil
+}
+
+func (s *stepCreateDisk) Cleanup(state multistep.StateBag) {
+	deleteVolumes(state, s.StoragePool, s.volumes)
+}
@@ -174,3 +174,35 @@ func Test_StepCreateCalled(t *testing.T) {
 			fmt.Sprintf("%s. Expected %#v", tc.Reason, tc.Expected))
 	}
 }
+
+func Test_StepCreateDisk_StoragePool(t *testing.T) {
+	step := &stepCreateDisk{
+		Format:             "qcow2",
+		DiskSize:           "4M",
+		VMName:             "target",
+		OutputDir:          "output",
+		StoragePool:        "default",
+		AdditionalDiskSize: []string{"1G"},
+	}
+
+	d := new(DriverMock)
+	d.CreateVolumeResults = []string{"/pool/target", "/pool/target-1"}
+	state := copyTestState(t, d)
+	action := step.Run(context.TODO(), state)
+	if action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+
+	if d.QemuImgCalled {
+		t.Fatalf("Should not have called qemu-img when storage_pool is set")
+	}
+	assert.Len(t, d.CreateVolumeCalls, 2)
+	assert.Contains(t, d.CreateVolumeCalls[0][1], "<name>target</name>")
+	assert.Contains(t, d.CreateVolumeCalls[0][1], "<capacity unit=\"bytes\">4194304</capacity>")
+	assert.Contains(t, d.CreateVolumeCalls[1][1], "<name>target-1</name>")
+	assert.Equal(t, []string{"output/target", "output/target-1"}, state.Get("qemu_disk_paths"))
+	assert.Equal(t, []string{"/pool/target", "/pool/target-1"}, state.Get("volume_paths"))
+
+	step.Cleanup(state)
+	assert.Equal(t, []string{"target-1", "target"}, d.DeleteVolumeCalls)
+}
@@ -0,0 +1,104 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// maxNetworkAttempts is how many free subnets are tried, since parallel
+// builds may pick the same subnet at the same time.
+const maxNetworkAttempts = 5
+
+// This step creates a transient NAT network on a free subnet for the build,
+// so that parallel builds don't share a network.
+//
+// Uses:
+//   driver Driver
+//   ui     packersdk.Ui
+//
+// Produces:
+//   net        string - The name of the network.
+//   net_bridge string - The bridge of the network.
+type stepCreateNetwork struct {
+	Range  string
+	VMName string
+
+	name string
+}
+
+func (s *stepCreateNetwork) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	_, pool, err := net.ParseCIDR(s.Range)
+	if err != nil {
+		err := fmt.Errorf("Error parsing ephemeral_network_range: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	used, err := driver.NetworkSubnets()
+	if err != nil {
+		err := fmt.Errorf("Error listing networks: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	subnets := freeSubnets(pool, used)
+	if len(subnets) == 0 {
+		err := fmt.Errorf("No free subnet left in %s", s.Range)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	if len(subnets) > maxNetworkAttempts {
+		subnets = subnets[:maxNetworkAttempts]
+	}
+
+	name := ephemeralNetworkName(s.VMName)
+	for _, subnet := range subnets {
+		networkXML, err := ephemeralNetworkXML(name, subnet)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+
+		ui.Say(fmt.Sprintf("Creating ephemeral network %s on %s...", name, subnet))
+		bridge, err := driver.CreateNetwork(networkXML)
+		if err != nil {
+			log.Printf("Error creating network on %s: %s", subnet, err)
+			continue
+		}
+
+		s.name = name
+		state.Put("net", s.name)
+		state.Put("net_bridge", bridge)
+		return multistep.ActionContinue
+	}
+
+	err = fmt.Errorf("Error creating ephemeral network, tried %d subnets of %s", len(subnets), s.Range)
+	state.Put("error", err)
+	ui.Error(err.Error())
+	return multistep.ActionHalt
+}
+
+func (s *stepCreateNetwork) Cleanup(state multistep.StateBag) {
+	if s.name == "" {
+		return
+	}
+
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	ui.Say(fmt.Sprintf("Destroying ephemeral network %s...", s.name))
+	if err := driver.DestroyNetwork(s.name); err != nil {
+		ui.Error(fmt.Sprintf("Error destroying network %s: %s", s.name, err))
+	}
+	s.name = ""
+}
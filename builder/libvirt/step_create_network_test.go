@@ -0,0 +1,54 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StepCreateNetwork(t *testing.T) {
+	state := testState(t)
+	d := state.Get("driver").(*DriverMock)
+	_, used, _ := net.ParseCIDR("10.213.0.0/24")
+	d.NetworkSubnetsResult = []*net.IPNet{used}
+	d.CreateNetworkBridge = "virbr3"
+	// Another build took the first free subnet in the meantime
+	d.CreateNetworkErrs = []error{errors.New("network is already in use by interface virbr2")}
+
+	step := &stepCreateNetwork{Range: "10.213.0.0/16", VMName: "packer-test"}
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued: %v", state.Get("error"))
+	}
+	if len(d.CreateNetworkCalls) != 2 {
+		t.Fatalf("should have tried two subnets, got %d", len(d.CreateNetworkCalls))
+	}
+	assert.True(t, strings.Contains(d.CreateNetworkCalls[0], `address="10.213.1.1"`))
+	assert.True(t, strings.Contains(d.CreateNetworkCalls[1], `address="10.213.2.1"`))
+	name := state.Get("net").(string)
+	assert.Regexp(t, `^packer-test-[0-9a-f]{8}$`, name)
+	assert.True(t, strings.Contains(d.CreateNetworkCalls[1], "<name>"+name+"</name>"))
+	assert.Equal(t, "virbr3", state.Get("net_bridge"))
+
+	step.Cleanup(state)
+	assert.Equal(t, []string{name}, d.DestroyNetworkCalls)
+}
+
+func Test_StepCreateNetwork_NoFreeSubnet(t *testing.T) {
+	state := testState(t)
+	d := state.Get("driver").(*DriverMock)
+	_, used, _ := net.ParseCIDR("10.0.0.0/8")
+	d.NetworkSubnetsResult = []*net.IPNet{used}
+
+	step := &stepCreateNetwork{Range: "10.213.0.0/16", VMName: "packer-test"}
+	if action := step.Run(context.TODO(), state); action != multistep.ActionHalt {
+		t.Fatalf("Should have halted")
+	}
+	step.Cleanup(state)
+	assert.Empty(t, d.CreateNetworkCalls)
+	assert.Empty(t, d.DestroyNetworkCalls)
+}
@@ -0,0 +1,66 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/net"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// This step picks the port of 127.0.0.1 the user-mode network of the VM
+// forwards to its communicator port, when network_mode is "user".
+//
+// Uses:
+//   config *config
+//   ui     packersdk.Ui
+//
+// Produces:
+//   commHostPort int - The port the communicator connects to.
+type stepForwardPort struct {
+	l *net.Listener
+}
+
+func (s *stepForwardPort) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	if config.NetworkMode != "user" || config.Comm.Type == "none" || config.Comm.Type == "guest-agent" {
+		return multistep.ActionContinue
+	}
+
+	msg := fmt.Sprintf("Looking for available communicator port between %d and %d", config.HostPortMin, config.HostPortMax)
+	ui.Say(msg)
+	log.Print(msg)
+
+	var err error
+	s.l, err = net.ListenRangeConfig{
+		Addr:    "127.0.0.1",
+		Min:     config.HostPortMin,
+		Max:     config.HostPortMax,
+		Network: "tcp",
+	}.Listen(ctx)
+	if err != nil {
+		err := fmt.Errorf("Error finding port: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s.l.Listener.Close() // free port, but don't unlock lock file
+
+	log.Printf("Forwarding port %d to port %d of the VM", s.l.Port, config.Comm.Port())
+	state.Put("commHostPort", s.l.Port)
+
+	return multistep.ActionContinue
+}
+
+func (s *stepForwardPort) Cleanup(multistep.StateBag) {
+	if s.l != nil {
+		err := s.l.Close()
+		if err != nil {
+			log.Printf("failed to unlock port lockfile: %v", err)
+		}
+	}
+}
@@ -3,62 +3,131 @@ package libvirt
 import (
 	"context"
 	"fmt"
+	"log"
 	"net"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
+// userNetworkGateway is the address of the host on QEMU's user-mode network.
+const userNetworkGateway = "10.0.2.2"
+
 // Step to discover the http ip
 // which guests use to reach the vm host
 // To make sure the IP is set before boot command and http server steps
+//
+// Uses:
+//   config *config
+//   driver Driver
+//   net    string
+//   ui     packersdk.Ui
+//
+// Produces:
+//   http_ip string - The address of the HTTP server, in brackets for IPv6.
 type stepHTTPIPDiscover struct{}
 
 func (s *stepHTTPIPDiscover) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	config := state.Get("config").(*Config)
 	ui := state.Get("ui").(packersdk.Ui)
 
-	hostIP := ""
-
-	bridgeInterface, err := net.InterfaceByName(config.NetBridge)
+	var hostIP net.IP
+	var err error
+	iface := config.CommunicatorInterface()
+	switch {
+	case config.HTTPIP != "":
+		hostIP = net.ParseIP(config.HTTPIP)
+	case config.NetworkMode == "user":
+		// QEMU's user-mode network forwards the gateway address to the host
+		hostIP = net.ParseIP(userNetworkGateway)
+	case config.HTTPInterface != "":
+		hostIP, err = interfaceIP(config.HTTPInterface, config.IPFamily)
+	case iface.Type() == "network":
+		hostIP, err = s.networkIP(state, iface.Network)
+	default:
+		hostIP, err = interfaceIP(hostBridge(state), config.IPFamily)
+	}
 	if err != nil {
-		err := fmt.Errorf("Error getting the bridge %s interface: %s", config.NetBridge, err)
+		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
-	addrs, err := bridgeInterface.Addrs()
+
+	log.Printf("Using %s as the HTTP IP", hostIP)
+	state.Put("http_ip", bracketIPv6(hostIP.String()))
+
+	return multistep.ActionContinue
+}
+
+// networkIP returns the gateway address of the libvirt network, which is
+// read through the libvirt connection so it works with remote hypervisors.
+func (s *stepHTTPIPDiscover) networkIP(state multistep.StateBag, netName string) (net.IP, error) {
+	config := state.Get("config").(*Config)
+	driver := state.Get("driver").(Driver)
+
+	if netName == "" {
+		netName = state.Get("net").(string)
+	}
+	desc, err := driver.NetworkDesc(netName)
 	if err != nil {
-		err := fmt.Errorf("Error getting the bridge %s interface addresses: %s", config.NetBridge, err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return nil, fmt.Errorf("Error reading network %s: %s", netName, err)
+	}
+	ip, err := networkGateway(desc, config.IPFamily)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading network %s: %s", netName, err)
+	}
+	// The guest can't reach a link-local address without knowing its zone
+	if ip == nil || ip.IsLinkLocalUnicast() {
+		return nil, fmt.Errorf("Error getting the gateway address of network %s: cannot find any address of ip_family %s, set http_ip", netName, config.IPFamily)
 	}
+	return ip, nil
+}
+
+// interfaceIP returns the address of the network interface of the Packer
+// host in the ip_family.
+func interfaceIP(name string, family string) (net.IP, error) {
+	netInterface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting the %s interface: %s", name, err)
+	}
+	addrs, err := netInterface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting the %s interface addresses: %s", name, err)
+	}
+	var ips []net.IP
 	for _, addr := range addrs {
-		var ip net.IP
 		switch v := addr.(type) {
 		case *net.IPNet:
-			ip = v.IP
+			ips = append(ips, v.IP)
 		case *net.IPAddr:
-			ip = v.IP
-		}
-		if ip == nil {
-			continue
-		}
-		ip = ip.To4()
-		if ip == nil {
-			continue
+			ips = append(ips, v.IP)
 		}
-		hostIP = ip.String()
-		break
 	}
-	if hostIP == "" {
-		err := fmt.Errorf("Error getting an IPv4 address from the bridge %s: cannot find any IPv4 address", config.NetBridge)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+	// The guest can't reach a link-local address without knowing its zone
+	ip := pickIP(ips, family)
+	if ip == nil || ip.IsLinkLocalUnicast() {
+		return nil, fmt.Errorf("Error getting an address from the %s interface: cannot find any address of ip_family %s", name, family)
 	}
+	return ip, nil
+}
 
-	state.Put("http_ip", hostIP)
+// hostBridge returns the device of the hypervisor the communicator interface
+// of the VM is connected to. The ephemeral network has a bridge of its own,
+// and bridge and direct interfaces are reached through their device.
+func hostBridge(state multistep.StateBag) string {
+	config := state.Get("config").(*Config)
 
-	return multistep.ActionContinue
+	bridge := config.NetBridge
+	if b, ok := state.GetOk("net_bridge"); ok {
+		bridge = b.(string)
+	}
+	switch iface := config.CommunicatorInterface(); iface.Type() {
+	case "bridge":
+		bridge = iface.Bridge
+	case "direct":
+		bridge = iface.Direct
+	}
+	return bridge
 }
 
 func (s *stepHTTPIPDiscover) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,70 @@
+package libvirt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StepHTTPIPDiscover(t *testing.T) {
+	network := `<network>
+	<name>default</name>
+	<ip address='192.168.122.1' netmask='255.255.255.0'/>
+	<ip family='ipv6' address='fd00:122::1' prefix='64'/>
+</network>`
+
+	cases := []struct {
+		name   string
+		config func(*Config)
+		httpIP string
+	}{
+		{"network gateway", func(c *Config) {}, "192.168.122.1"},
+		{"network gateway ipv6", func(c *Config) { c.IPFamily = "ipv6" }, "[fd00:122::1]"},
+		{"http_ip", func(c *Config) { c.HTTPIP = "10.0.0.5" }, "10.0.0.5"},
+		{"http_ip ipv6", func(c *Config) { c.HTTPIP = "fd00::5" }, "[fd00::5]"},
+		{"user network", func(c *Config) { c.NetworkMode = "user" }, "10.0.2.2"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := runTestConfig()
+			config.IPFamily = "ipv4"
+			tc.config(config)
+			state := runTestState(t, config)
+			d := state.Get("driver").(*DriverMock)
+			d.NetworkDescResult = network
+
+			step := new(stepHTTPIPDiscover)
+			if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+				t.Fatalf("Should have continued: %v", state.Get("error"))
+			}
+			assert.Equal(t, tc.httpIP, state.Get("http_ip"))
+		})
+	}
+}
+
+func Test_StepHTTPIPDiscover_MissingInterface(t *testing.T) {
+	config := runTestConfig()
+	config.HTTPInterface = "packer-missing0"
+	state := runTestState(t, config)
+
+	step := new(stepHTTPIPDiscover)
+	if action := step.Run(context.TODO(), state); action != multistep.ActionHalt {
+		t.Fatal("Should have halted")
+	}
+}
+
+func Test_StepHTTPIPDiscover_NoGateway(t *testing.T) {
+	config := runTestConfig()
+	config.IPFamily = "ipv6"
+	state := runTestState(t, config)
+	d := state.Get("driver").(*DriverMock)
+	d.NetworkDescResult = `<network><name>default</name><ip address='192.168.122.1' prefix='24'/></network>`
+
+	step := new(stepHTTPIPDiscover)
+	if action := step.Run(context.TODO(), state); action != multistep.ActionHalt {
+		t.Fatal("Should have halted")
+	}
+}
@@ -0,0 +1,125 @@
+package libvirt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// consoleLogSuffix is appended to the VM name to name the console log in the
+// output directory.
+const consoleLogSuffix = "-console.log"
+
+// consoleStopTimeout is how long Cleanup waits for the console stream to end.
+const consoleStopTimeout = 2 * time.Second
+
+// This step opens the serial console of the running domain, so that later
+// steps can wait on its output, and writes the output to the console log in
+// the output directory.
+//
+// Uses:
+//   config *config
+//   driver Driver
+//   ui     packersdk.Ui
+//
+// Produces:
+//   serial_console *serialConsole - The console output of the domain.
+type stepOpenConsole struct {
+	logFile *os.File
+	console *serialConsole
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func (s *stepOpenConsole) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	logPath := filepath.Join(config.OutputDir, config.VMName+consoleLogSuffix)
+	f, err := os.Create(logPath)
+	if err != nil {
+		err := fmt.Errorf("Error creating console log: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s.logFile = f
+	log.Printf("Writing serial console to %s", logPath)
+
+	console := newSerialConsole()
+	console.AddWriter(f)
+	if config.ConsoleToUI {
+		console.AddWriter(&uiLineWriter{ui: ui, prefix: "console: "})
+	}
+
+	consoleCtx, cancel := context.WithCancel(context.Background())
+	s.console, s.cancel, s.done = console, cancel, make(chan struct{})
+	go func() {
+		defer close(s.done)
+		// OpenConsole blocks until the domain goes away
+		err := driver.OpenConsole(consoleCtx, console)
+		if err != nil {
+			log.Printf("Serial console closed: %s", err)
+		}
+		console.Close(err)
+	}()
+	state.Put("serial_console", console)
+
+	return multistep.ActionContinue
+}
+
+func (s *stepOpenConsole) Cleanup(state multistep.StateBag) {
+	if s.console != nil {
+		// Nothing writes to the log file once the console is stopped. An idle
+		// console stream only ends when stepRun destroys the domain, which
+		// happens after this, so the wait for it is bounded.
+		s.cancel()
+		s.console.Stop()
+		select {
+		case <-s.done:
+		case <-time.After(consoleStopTimeout):
+			log.Printf("Serial console still open, closing the console log anyway")
+		}
+	}
+	if s.logFile != nil {
+		s.logFile.Close()
+	}
+}
+
+// uiLineWriter shows every complete line written to it as a message in the
+// Packer UI.
+type uiLineWriter struct {
+	ui     packersdk.Ui
+	prefix string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *uiLineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+		w.ui.Message(w.prefix + line)
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,84 @@
+package libvirt
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StepOpenConsole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := runTestConfig()
+	config.OutputDir = dir
+	config.ConsoleToUI = true
+	state := runTestState(t, config)
+	d := state.Get("driver").(*DriverMock)
+	d.OpenConsoleOutput = "Booting...\r\nlogin: "
+	ui := state.Get("ui").(*packersdk.BasicUi)
+
+	step := new(stepOpenConsole)
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued")
+	}
+	console := state.Get("serial_console").(*serialConsole)
+	if err := console.WaitFor(context.TODO(), regexp.MustCompile("login: "), time.Second); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	step.Cleanup(state)
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "packer-test-console.log"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "Booting...\r\nlogin: ", string(contents))
+	assert.Equal(t, "console: Booting...\n", ui.Writer.(*bytes.Buffer).String())
+}
+
+func Test_StepPrepareOutputDir_KeepConsoleLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := runTestConfig()
+	config.OutputDir = dir
+	state := runTestState(t, config)
+	state.Put(multistep.StateHalted, true)
+
+	for _, name := range []string{"packer-test", "packer-test-console.log"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "screenshots"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	stepPrepareOutputDir{}.Cleanup(state)
+
+	if _, err := os.Stat(filepath.Join(dir, "packer-test")); !os.IsNotExist(err) {
+		t.Fatalf("disk should have been deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "packer-test-console.log")); err != nil {
+		t.Fatalf("console log should have been kept: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "screenshots")); err != nil {
+		t.Fatalf("screenshots should have been kept: %s", err)
+	}
+}
@@ -2,8 +2,12 @@ package libvirt
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
@@ -37,6 +41,20 @@ func (stepPrepareOutputDir) Cleanup(state multistep.StateBag) {
 		config := state.Get("config").(*Config)
 		ui := state.Get("ui").(packersdk.Ui)
 
+		// Keep the console log and screenshots around, they usually tell
+		// why the build failed
+		var keep []string
+		for _, name := range []string{config.VMName + consoleLogSuffix, screenshotDir} {
+			if _, err := os.Stat(filepath.Join(config.OutputDir, name)); err == nil {
+				keep = append(keep, name)
+			}
+		}
+		if len(keep) > 0 {
+			ui.Say(fmt.Sprintf("Deleting output directory, keeping %s...", strings.Join(keep, ", ")))
+			removeOutputDirExcept(config.OutputDir, keep...)
+			return
+		}
+
 		ui.Say("Deleting output directory...")
 		for i := 0; i < 5; i++ {
 			err := os.RemoveAll(config.OutputDir)
@@ -49,3 +67,30 @@ func (stepPrepareOutputDir) Cleanup(state multistep.StateBag) {
 		}
 	}
 }
+
+// removeOutputDirExcept removes everything in the output directory but the
+// given files.
+func removeOutputDirExcept(dir string, keep ...string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Printf("Error reading output dir: %s", err)
+		return
+	}
+	for _, entry := range entries {
+		if isKept(entry.Name(), keep) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			log.Printf("Error removing %s from output dir: %s", entry.Name(), err)
+		}
+	}
+}
+
+func isKept(name string, keep []string) bool {
+	for _, k := range keep {
+		if name == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// This step reserves an address for the MAC address of the interface the
+// communicator uses in the DHCP server of its network, so the address of the
+// VM is known before it boots and stale leases of other builds can't be
+// picked up. Domains defined by xml_file are skipped, since their template
+// doesn't have to use the MAC address.
+//
+// Uses:
+//   config *config
+//   driver Driver
+//   net    string
+//   ui     packersdk.Ui
+//
+// Produces:
+//   guest_ip string - The reserved address, unless the network has no DHCP.
+type stepReserveIP struct {
+	network string
+	hostXML string
+}
+
+func (s *stepReserveIP) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	if config.XMLFile != "" {
+		log.Printf("The domain is defined by xml_file, not reserving an address")
+		return multistep.ActionContinue
+	}
+	iface := config.CommunicatorInterface()
+	if config.NetworkMode == "user" || iface.Type() != "network" {
+		log.Printf("Interface %s is not on a libvirt network, not reserving an address", iface.MACAddress)
+		return multistep.ActionContinue
+	}
+	netName := iface.Network
+	if netName == "" {
+		netName = state.Get("net").(string)
+	}
+
+	desc, err := driver.NetworkDesc(netName)
+	if err != nil {
+		err := fmt.Errorf("Error reading network %s: %s", netName, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	leased, err := driver.NetworkLeases(netName)
+	if err != nil {
+		err := fmt.Errorf("Error reading DHCP leases of network %s: %s", netName, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	reservation, err := pickDHCPReservation(desc, config.MACAddress, leased)
+	if err != nil {
+		err := fmt.Errorf("Error picking an address in network %s: %s", netName, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	if reservation == nil {
+		log.Printf("Network %s has no DHCP server, not reserving an address", netName)
+		return multistep.ActionContinue
+	}
+
+	if !reservation.Existing {
+		hostXML, err := dhcpHostXML(config.MACAddress, reservation.IP)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Say(fmt.Sprintf("Reserving %s for %s in network %s...", reservation.IP, config.MACAddress, netName))
+		if err := driver.AddDHCPHost(netName, hostXML); err != nil {
+			err := fmt.Errorf("Error reserving address in network %s: %s", netName, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		s.network = netName
+		s.hostXML = hostXML
+	} else {
+		log.Printf("Network %s already has %s reserved for %s", netName, reservation.IP, config.MACAddress)
+	}
+
+	state.Put("guest_ip", reservation.IP)
+	return multistep.ActionContinue
+}
+
+func (s *stepReserveIP) Cleanup(state multistep.StateBag) {
+	if s.hostXML == "" {
+		return
+	}
+
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	if err := driver.DeleteDHCPHost(s.network, s.hostXML); err != nil {
+		ui.Error(fmt.Sprintf("Error removing DHCP reservation from network %s: %s", s.network, err))
+	}
+	s.hostXML = ""
+}
@@ -0,0 +1,67 @@
+package libvirt
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StepReserveIP(t *testing.T) {
+	state := runTestState(t, runTestConfig())
+	d := state.Get("driver").(*DriverMock)
+	d.NetworkDescResult = `<network>
+	<name>default</name>
+	<ip address='192.168.122.1' netmask='255.255.255.0'>
+		<dhcp><range start='192.168.122.2' end='192.168.122.254'/></dhcp>
+	</ip>
+</network>`
+
+	step := new(stepReserveIP)
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued: %v", state.Get("error"))
+	}
+	host := `<host mac="52:54:00:12:34:56" ip="192.168.122.254"></host>`
+	assert.Equal(t, []string{host}, d.AddDHCPHostCalls)
+	assert.Equal(t, "192.168.122.254", state.Get("guest_ip"))
+
+	step.Cleanup(state)
+	assert.Equal(t, []string{host}, d.DeleteDHCPHostCalls)
+}
+
+func Test_StepReserveIP_NoDHCP(t *testing.T) {
+	state := runTestState(t, runTestConfig())
+	d := state.Get("driver").(*DriverMock)
+	d.NetworkDescResult = `<network><name>default</name><ip address='10.0.0.1' prefix='24'/></network>`
+
+	step := new(stepReserveIP)
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued: %v", state.Get("error"))
+	}
+	step.Cleanup(state)
+	if _, ok := state.GetOk("guest_ip"); ok {
+		t.Fatal("shouldn't have reserved an address")
+	}
+	assert.Empty(t, d.AddDHCPHostCalls)
+	assert.Empty(t, d.DeleteDHCPHostCalls)
+}
+
+func Test_StepReserveIP_XMLFile(t *testing.T) {
+	// The template doesn't use {{ .MACAddress }}, so the domain boots with
+	// another MAC address than the reserved one
+	config := runTestConfig()
+	config.XMLFile = filepath.Join("testdata", "xml-file.tmpl")
+	state := runTestState(t, config)
+	d := state.Get("driver").(*DriverMock)
+
+	step := new(stepReserveIP)
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued: %v", state.Get("error"))
+	}
+	assert.Empty(t, d.AddDHCPHostCalls)
+	if _, ok := state.GetOk("guest_ip"); ok {
+		t.Fatal("Should not have put guest_ip")
+	}
+}
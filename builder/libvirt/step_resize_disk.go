@@ -19,6 +19,7 @@ type stepResizeDisk struct {
 	SkipResizeDisk  bool
 	VMName          string
 	DiskSize        string
+	StoragePool     string
 
 	QemuImgArgs QemuImgArgs
 }
@@ -35,6 +36,20 @@ func (s *stepResizeDisk) Run(ctx context.Context, state multistep.StateBag) mult
 	}
 
 	ui.Say("Resizing hard drive...")
+	if s.StoragePool != "" {
+		size, err := diskSizeBytes(s.DiskSize)
+		if err == nil {
+			err = driver.ResizeVolume(s.StoragePool, s.VMName, size)
+		}
+		if err != nil {
+			err := fmt.Errorf("Error resizing hard drive: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		return multistep.ActionContinue
+	}
+
 	if err := driver.QemuImg(command...); err != nil {
 		err := fmt.Errorf("Error creating hard drive: %s", err)
 		state.Put("error", err)
@@ -75,3 +75,25 @@ func Test_buildResizeCommand(t *testing.T) {
 			fmt.Sprintf("%s. Expected %#v", tc.Reason, tc.Expected))
 	}
 }
+
+func Test_StepResizeDisk_StoragePool(t *testing.T) {
+	step := &stepResizeDisk{
+		DiskImage:   true,
+		DiskSize:    "2G",
+		VMName:      "target",
+		StoragePool: "default",
+	}
+
+	d := new(DriverMock)
+	state := testState(t)
+	state.Put("driver", d)
+	action := step.Run(context.TODO(), state)
+	if action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+	if d.QemuImgCalled {
+		t.Fatalf("Should not have called qemu-img when storage_pool is set")
+	}
+	assert.Equal(t, []string{"target"}, d.ResizeVolumeCalls)
+	assert.Equal(t, []uint64{2 << 30}, d.ResizeVolumeSizes)
+}
@@ -28,21 +28,40 @@ type XMLTemplateData struct {
 	VMName      string
 	Disks       []Disk
 	IsoPath     string
+	CDPath      string
+
+	Kernel        string
+	Initrd        string
+	KernelCmdline string
+	MACAddress    string
+	GuestIP       string
+	Interfaces    []Interface
+	NVRAM         string
+	TPMStatePath  string
 }
 
-var XmlTemplate string = `<domain type='{{.Hypervisor}}'>
+var XmlTemplate string = `<domain type='{{.Hypervisor}}'{{if .UserNetwork}} xmlns:qemu='http://libvirt.org/schemas/domain/qemu/1.0'{{end}}>
 	<name>{{.Name}}</name>
 	<vcpu>{{.Vcpu}}</vcpu>
 	<memory unit='MiB'>{{.Memory}}</memory>
-	<os>
+	<os{{if .Firmware}} firmware='{{.Firmware}}'{{end}}>
 		<type arch='{{.Arch}}' machine='{{.Machine}}'>hvm</type>
-		{{if .Loader}}<loader readonly='yes' type='pflash'>{{.Loader}}</loader>{{end}}
+		{{if .Loader}}<loader readonly='yes' type='pflash'>{{.Loader}}</loader>{{end}}{{if eq .Firmware "efi"}}<firmware>
+			<feature enabled='{{if .SecureBoot}}yes{{else}}no{{end}}' name='secure-boot'/>{{if .SecureBoot}}
+			<feature enabled='{{if .EnrolledKeys}}yes{{else}}no{{end}}' name='enrolled-keys'/>{{end}}
+		</firmware>{{if .SecureBoot}}
+		<loader secure='yes'/>{{end}}{{end}}{{if or .NVRAM .NVRAMTemplate}}
+		<nvram{{if .NVRAMTemplate}} template='{{.NVRAMTemplate}}'{{end}}>{{.NVRAM}}</nvram>{{end}}
+		{{if .Kernel}}<kernel>{{.Kernel}}</kernel>{{end}}
+		{{if .Initrd}}<initrd>{{.Initrd}}</initrd>{{end}}
+		{{if .KernelCmdline}}<cmdline>{{.KernelCmdline}}</cmdline>{{end}}
 		<boot dev='hd'/>
 		<boot dev='cdrom'/>
 	</os>
 	<features>
 		<acpi/>
-		<apic/>
+		<apic/>{{if .SecureBoot}}
+		<smm state='on'/>{{end}}
 	</features>
 	{{if eq .CPUMode "host-passthrough" "host-model"}}
 	<cpu mode='{{.CPUMode}}'>
@@ -74,6 +93,14 @@ var XmlTemplate string = `<domain type='{{.Hypervisor}}'>
 		<readonly/>
 	</disk>
 	{{end}}
+	{{if .CDPath}}
+	<disk type='file' device='cdrom'>
+		<driver name='qemu' type='raw'/>
+		<source file='{{.CDPath}}'/>
+		<target dev='sde' bus='{{.Cdrom.Interface}}'/>
+		<readonly/>
+	</disk>
+	{{end}}
 	{{if .FloppyPath}}
 	<disk type='file' device='floppy'>
 		<driver name='qemu' type='raw'/>
@@ -88,11 +115,12 @@ var XmlTemplate string = `<domain type='{{.Hypervisor}}'>
 	<controller type='scsi' index='0' model='virtio-scsi'>
 		<address type='pci' domain='0x0000' bus='0x02' slot='0x02' function='0x0'/>
 	</controller>
-	<interface type='network'>
-		<source network='{{.NetName}}'/>
-		<model type='{{.NetDevice}}'/>
+	{{range .Interfaces}}<interface type='{{.Type}}'>
+		{{if .MACAddress}}<mac address='{{.MACAddress}}'/>{{end}}
+		{{if eq .Type "bridge"}}<source bridge='{{.Source}}'/>{{else if eq .Type "direct"}}<source dev='{{.Source}}' mode='{{.Mode}}'/>{{else}}<source network='{{.Source}}'/>{{end}}
+		<model type='{{.Model}}'/>
 	</interface>
-	<serial type='pty'>
+	{{end}}<serial type='pty'>
 		<source path='/dev/pts/0'/>
 		<target type='{{if eq .Arch "x86_64"}}isa-serial{{else}}system-serial{{end}}' port='0'/>
 	</serial>
@@ -100,6 +128,9 @@ var XmlTemplate string = `<domain type='{{.Hypervisor}}'>
 		<source path='/dev/pts/0'/>
 		<target type='serial' port='0'/>
 	</console>
+	<channel type='unix'>
+		<target type='virtio' name='org.qemu.guest_agent.0'/>
+	</channel>
 	<input type='tablet'>
 		<alias name='input0'/>
 	</input>
@@ -114,30 +145,77 @@ var XmlTemplate string = `<domain type='{{.Hypervisor}}'>
 	</video>
 	<memballoon model='virtio'>
 		<address type='pci' domain='0x0000' bus='0x00' slot='0x08' function='0x0'/>
-	</memballoon>
+	</memballoon>{{with .TPM}}
+	<tpm model='{{.Model}}'>
+		<backend type='emulator' version='2.0'{{if .PersistentState}} persistent_state='yes'{{end}}{{if .StatePath}}>
+			<source type='dir' path='{{.StatePath}}'/>
+		</backend>{{else}}/>{{end}}
+	</tpm>{{end}}
 	</devices>	
-</domain>
+{{with .UserNetwork}}	<qemu:commandline>
+		<qemu:arg value='-netdev'/>
+		<qemu:arg value='user,id=packer0{{if .HostPort}},hostfwd=tcp:127.0.0.1:{{.HostPort}}-:{{.GuestPort}}{{end}}'/>
+		<qemu:arg value='-device'/>
+		<qemu:arg value='{{.Model}},netdev=packer0,mac={{.MACAddress}}'/>
+	</qemu:commandline>
+{{end}}</domain>
 `
 
 type LibvirtXML struct {
-	Hypervisor  string
-	Name        string
-	Vcpu        int
-	Memory      int
-	Loader      string
-	Arch        string
-	Machine     string
-	CPUMode     string
-	Emulator    string
-	DiskImage   bool
-	Disks       []Disk
-	Cdrom       Cdrom
-	FloppyPath  string
-	NetName     string
-	NetDevice   string
-	VncIP       string
-	VncPort     int
-	VncPassword string
+	Hypervisor    string
+	Name          string
+	Vcpu          int
+	Memory        int
+	Loader        string
+	Firmware      string
+	SecureBoot    bool
+	EnrolledKeys  bool
+	NVRAM         string
+	NVRAMTemplate string
+	Kernel        string
+	Initrd        string
+	KernelCmdline string
+	Arch          string
+	Machine       string
+	CPUMode       string
+	Emulator      string
+	DiskImage     bool
+	Disks         []Disk
+	Cdrom         Cdrom
+	CDPath        string
+	FloppyPath    string
+	Interfaces    []Interface
+	UserNetwork   *UserNetwork
+	TPM           *TPM
+	VncIP         string
+	VncPort       int
+	VncPassword   string
+}
+
+type Interface struct {
+	Type       string
+	Source     string
+	Mode       string
+	Model      string
+	MACAddress string
+}
+
+// UserNetwork is the interface on the user-mode network of QEMU, which
+// libvirt can only forward ports to through the QEMU command line.
+type UserNetwork struct {
+	Model      string
+	MACAddress string
+	HostPort   int
+	GuestPort  int
+}
+
+// TPM is the emulated TPM 2.0 of the domain. Its state is kept in
+// StatePath when set, and PersistentState keeps it when a transient domain
+// stops.
+type TPM struct {
+	Model           string
+	PersistentState bool
+	StatePath       string
 }
 
 type Disk struct {
@@ -192,12 +270,54 @@ func (s *stepRun) getXMLDesc(state multistep.StateBag) (string, error) {
 	vncIP := config.VNCBindAddress
 	vncPort := state.Get("vnc_port").(int)
 	vncPassword := state.Get("vnc_password").(string)
+	guestIP, _ := state.Get("guest_ip").(string)
+
+	var interfaces []Interface
+	var userNetwork *UserNetwork
+	if config.NetworkMode == "user" {
+		iface := config.CommunicatorInterface()
+		userNetwork = &UserNetwork{
+			Model:      iface.Model,
+			MACAddress: iface.MACAddress,
+		}
+		if hostPort, ok := state.GetOk("commHostPort"); ok {
+			userNetwork.HostPort = hostPort.(int)
+			userNetwork.GuestPort = config.Comm.Port()
+		}
+	} else {
+		for _, iface := range config.NetworkInterfaces {
+			i := Interface{
+				Type:       iface.Type(),
+				Source:     iface.Network,
+				Mode:       iface.DirectMode,
+				Model:      iface.Model,
+				MACAddress: iface.MACAddress,
+			}
+			switch i.Type {
+			case "bridge":
+				i.Source = iface.Bridge
+			case "direct":
+				i.Source = iface.Direct
+			default:
+				if i.Source == "" {
+					i.Source = netName
+				}
+			}
+			interfaces = append(interfaces, i)
+		}
+	}
 
 	isoPath := state.Get("iso_path").(string)
+	if isoVolumePath, ok := state.GetOk("iso_volume_path"); ok {
+		isoPath = isoVolumePath.(string)
+	}
 
 	var disks []Disk
 	if !config.DiskImage {
 		qemu_disk_paths := state.Get("qemu_disk_paths").([]string)
+		if volumePaths, ok := state.GetOk("volume_paths"); ok {
+			qemu_disk_paths = volumePaths.([]string)
+		}
 		for i, diskPath := range qemu_disk_paths {
 			if fullPath, err := filepath.Abs(diskPath); err != nil {
 				return "", err
@@ -217,6 +337,9 @@ func (s *stepRun) getXMLDesc(state multistep.StateBag) (string, error) {
 		vmName := config.VMName
 		outputDir, _ := filepath.Abs(config.OutputDir)
 		imgPath := filepath.Join(outputDir, vmName)
+		if volumePaths, ok := state.GetOk("volume_paths"); ok {
+			imgPath = volumePaths.([]string)[0]
+		}
 		disks = append(disks, Disk{
 			config.Format,
 			imgPath,
@@ -232,6 +355,60 @@ func (s *stepRun) getXMLDesc(state multistep.StateBag) (string, error) {
 	if floppyPathRaw, ok := state.GetOk("floppy_path"); ok {
 		floppyPath = floppyPathRaw.(string)
 	}
+	if floppyVolumePath, ok := state.GetOk("floppy_volume_path"); ok {
+		floppyPath = floppyVolumePath.(string)
+	}
+
+	cdPath := ""
+	if cdPathRaw, ok := state.GetOk("cd_path"); ok {
+		fullPath, err := filepath.Abs(cdPathRaw.(string))
+		if err != nil {
+			return "", err
+		}
+		cdPath = fullPath
+	}
+	if cdVolumePath, ok := state.GetOk("cd_volume_path"); ok {
+		cdPath = cdVolumePath.(string)
+	}
+
+	// The NVRAM of persistent domains is kept in the output directory, so
+	// it's part of the artifact. libvirt picks its path when the disks are
+	// in a storage pool.
+	nvramPath := ""
+	if config.DomainType == "persistent" && (config.Loader != "" || config.Firmware == "efi") && config.StoragePool == "" {
+		outputDir, err := filepath.Abs(config.OutputDir)
+		if err != nil {
+			return "", err
+		}
+		nvramPath = filepath.Join(outputDir, config.VMName+"_VARS.fd")
+		state.Put("nvram_path", nvramPath)
+	}
+
+	// The TPM state in the output directory is part of the artifact.
+	tpmStatePath := ""
+	if config.TPMPersistentState {
+		outputDir, err := filepath.Abs(config.OutputDir)
+		if err != nil {
+			return "", err
+		}
+		tpmStatePath = filepath.Join(outputDir, config.VMName+"_tpm")
+		state.Put("tpm_state_path", tpmStatePath)
+	}
+	var tpm *TPM
+	if config.TPM {
+		// libvirt rejects persistent_state for persistent domains, which
+		// keep the state when they're undefined instead
+		tpm = &TPM{
+			Model:           config.TPMModel,
+			PersistentState: tpmStatePath != "" && config.DomainType != "persistent",
+			StatePath:       tpmStatePath,
+		}
+	}
+
+	kernelCmdline, err := s.renderKernelCmdline(state)
+	if err != nil {
+		return "", err
+	}
 
 	if config.XMLFile != "" {
 		s.ui.Say("Overriding defaults libvirt xml with user defined xml")
@@ -248,6 +425,16 @@ func (s *stepRun) getXMLDesc(state multistep.StateBag) (string, error) {
 			VMName:      config.VMName,
 			Disks:       disks,
 			IsoPath:     isoPath,
+			CDPath:      cdPath,
+
+			Kernel:        config.Kernel,
+			Initrd:        config.Initrd,
+			KernelCmdline: kernelCmdline,
+			MACAddress:    config.MACAddress,
+			GuestIP:       guestIP,
+			Interfaces:    interfaces,
+			NVRAM:         nvramPath,
+			TPMStatePath:  tpmStatePath,
 		}
 
 		userData, err := interpolate.Render(string(oriData), &configCtx)
@@ -259,24 +446,34 @@ func (s *stepRun) getXMLDesc(state multistep.StateBag) (string, error) {
 	}
 
 	libvirtXML := LibvirtXML{
-		Hypervisor:  config.Hypervisor,
-		Name:        config.VMName,
-		Vcpu:        config.CpuCount,
-		Memory:      config.MemorySize,
-		Loader:      config.Loader,
-		Arch:        config.Arch,
-		Machine:     config.MachineType,
-		CPUMode:     config.CPUMode,
-		Emulator:    config.EmulatorBinary,
-		DiskImage:   config.DiskImage,
-		Disks:       disks,
-		Cdrom:       Cdrom{Source: isoPath, Interface: config.CDROMInterface},
-		FloppyPath:  floppyPath,
-		NetName:     netName,
-		NetDevice:   config.NetDevice,
-		VncIP:       vncIP,
-		VncPort:     vncPort,
-		VncPassword: vncPassword,
+		Hypervisor:    config.Hypervisor,
+		Name:          config.VMName,
+		Vcpu:          config.CpuCount,
+		Memory:        config.MemorySize,
+		Loader:        config.Loader,
+		Firmware:      config.Firmware,
+		SecureBoot:    config.SecureBoot,
+		EnrolledKeys:  config.EnrolledKeys.True(),
+		NVRAM:         nvramPath,
+		NVRAMTemplate: config.NVRAMTemplate,
+		Kernel:        config.Kernel,
+		Initrd:        config.Initrd,
+		KernelCmdline: kernelCmdline,
+		Arch:          config.Arch,
+		Machine:       config.MachineType,
+		CPUMode:       config.CPUMode,
+		Emulator:      config.EmulatorBinary,
+		DiskImage:     config.DiskImage,
+		Disks:         disks,
+		Cdrom:         Cdrom{Source: isoPath, Interface: config.CDROMInterface},
+		CDPath:        cdPath,
+		FloppyPath:    floppyPath,
+		Interfaces:    interfaces,
+		UserNetwork:   userNetwork,
+		TPM:           tpm,
+		VncIP:         vncIP,
+		VncPort:       vncPort,
+		VncPassword:   vncPassword,
 	}
 	t, err := template.New("xml").Parse(XmlTemplate)
 	if err != nil {
@@ -289,6 +486,27 @@ func (s *stepRun) getXMLDesc(state multistep.StateBag) (string, error) {
 	return b.String(), nil
 }
 
+// renderKernelCmdline interpolates kernel_cmdline with the same variables as
+// boot_command, so the kernel can fetch its kickstart from the HTTP server.
+func (s *stepRun) renderKernelCmdline(state multistep.StateBag) (string, error) {
+	config := state.Get("config").(*Config)
+	if config.KernelCmdline == "" {
+		return "", nil
+	}
+
+	httpIP, _ := state.Get("http_ip").(string)
+	httpPort, _ := state.Get("http_port").(int)
+	guestIP, _ := state.Get("guest_ip").(string)
+	configCtx := config.ctx
+	configCtx.Data = &bootCommandTemplateData{
+		httpIP,
+		httpPort,
+		config.VMName,
+		guestIP,
+	}
+	return interpolate.Render(config.KernelCmdline, &configCtx)
+}
+
 func (s *stepRun) Cleanup(state multistep.StateBag) {
 	driver := state.Get("driver").(Driver)
 	ui := state.Get("ui").(packersdk.Ui)
@@ -296,4 +514,10 @@ func (s *stepRun) Cleanup(state multistep.StateBag) {
 	if err := driver.Stop(); err != nil {
 		ui.Error(fmt.Sprintf("Error shutting down VM: %s", err))
 	}
+
+	// The NVRAM in the output directory is part of the artifact
+	_, keepNVRAM := state.GetOk("nvram_path")
+	if err := driver.Undefine(keepNVRAM); err != nil {
+		ui.Error(fmt.Sprintf("Error undefining VM: %s", err))
+	}
 }
@@ -0,0 +1,237 @@
+package libvirt
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden XML files in testdata")
+
+func runTestConfig() *Config {
+	return &Config{
+		Hypervisor:     "kvm",
+		VMName:         "packer-test",
+		CpuCount:       2,
+		MemorySize:     1024,
+		Arch:           "x86_64",
+		MachineType:    "pc",
+		CPUMode:        "host-passthrough",
+		EmulatorBinary: "/usr/libexec/qemu-kvm",
+		Format:         "qcow2",
+		DiskInterface:  "virtio",
+		DiskCache:      "writeback",
+		DiskDiscard:    "ignore",
+		DetectZeroes:   "off",
+		CDROMInterface: "scsi",
+		MACAddress:     "52:54:00:12:34:56",
+		NetDevice:      "virtio-net",
+		NetworkInterfaces: []NetworkInterface{{
+			Model:        "virtio-net",
+			MACAddress:   "52:54:00:12:34:56",
+			Communicator: true,
+		}},
+		OutputDir:      "/output",
+		VNCBindAddress: "127.0.0.1",
+	}
+}
+
+func runTestState(t *testing.T, config *Config) multistep.StateBag {
+	state := testState(t)
+	state.Put("config", config)
+	state.Put("net", "default")
+	state.Put("vnc_port", 5901)
+	state.Put("vnc_password", "")
+	state.Put("iso_path", "/isos/install.iso")
+	state.Put("qemu_disk_paths", []string{"/output/packer-test"})
+	return state
+}
+
+// assertGoldenXML compares the generated domain XML with testdata/<name>.
+// Run the tests with -update to regenerate the golden files.
+func assertGoldenXML(t *testing.T, name string, actual string) {
+	golden := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, []byte(actual), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, string(expected), actual, "domain XML differs from %s", golden)
+}
+
+func Test_StepRun_getXMLDesc(t *testing.T) {
+	type testCase struct {
+		Golden string
+		Config func(*Config)
+		State  func(multistep.StateBag)
+	}
+	testcases := []testCase{
+		{
+			"iso.xml",
+			func(c *Config) {},
+			func(state multistep.StateBag) {},
+		},
+		{
+			"iso-cd-files.xml",
+			func(c *Config) {},
+			func(state multistep.StateBag) {
+				state.Put("cd_path", "/tmp/packer123.iso")
+			},
+		},
+		{
+			"iso-media-pool.xml",
+			func(c *Config) {},
+			func(state multistep.StateBag) {
+				state.Put("cd_path", "/tmp/packer123.iso")
+				state.Put("cd_volume_path", "/var/lib/libvirt/images/packer-test-cd.iso")
+				state.Put("floppy_path", "/tmp/packer123.vfd")
+				state.Put("floppy_volume_path", "/var/lib/libvirt/images/packer-test.vfd")
+			},
+		},
+		{
+			"iso-kernel.xml",
+			func(c *Config) {
+				c.Kernel = "/var/lib/libvirt/boot/vmlinuz"
+				c.Initrd = "/var/lib/libvirt/boot/initrd.img"
+				c.KernelCmdline = "inst.ks=http://{{ .HTTPIP }}:{{ .HTTPPort }}/ks.cfg ip={{ .GuestIP }}::192.168.122.1:255.255.255.0::eth0:none console=ttyS0"
+			},
+			func(state multistep.StateBag) {
+				state.Put("http_ip", "192.168.122.1")
+				state.Put("http_port", 8080)
+				state.Put("guest_ip", "192.168.122.254")
+			},
+		},
+		{
+			"iso-network-interfaces.xml",
+			func(c *Config) {
+				c.NetworkInterfaces = append(c.NetworkInterfaces,
+					NetworkInterface{Network: "provisioning", Model: "e1000", MACAddress: "52:54:00:12:34:57"},
+					NetworkInterface{Bridge: "br0", Model: "virtio-net", MACAddress: "52:54:00:12:34:58"},
+					NetworkInterface{Direct: "eth1", DirectMode: "vepa", Model: "virtio-net", MACAddress: "52:54:00:12:34:59"},
+				)
+			},
+			func(state multistep.StateBag) {},
+		},
+		{
+			"iso-user-network.xml",
+			func(c *Config) {
+				c.NetworkMode = "user"
+				c.Comm.Type = "ssh"
+				c.Comm.SSHPort = 22
+			},
+			func(state multistep.StateBag) {
+				state.Put("commHostPort", 2222)
+			},
+		},
+		{
+			"iso-persistent-uefi.xml",
+			func(c *Config) {
+				c.DomainType = "persistent"
+				c.Loader = "/usr/share/OVMF/OVMF_CODE.fd"
+				c.NVRAMTemplate = "/usr/share/OVMF/OVMF_VARS.fd"
+			},
+			func(state multistep.StateBag) {},
+		},
+		{
+			"iso-uefi-secure-boot.xml",
+			func(c *Config) {
+				c.Firmware = "efi"
+				c.SecureBoot = true
+				c.EnrolledKeys = config.TriTrue
+				c.MachineType = "q35"
+			},
+			func(state multistep.StateBag) {},
+		},
+		{
+			"iso-tpm.xml",
+			func(c *Config) {
+				c.TPM = true
+				c.TPMModel = "tpm-crb"
+			},
+			func(state multistep.StateBag) {},
+		},
+		{
+			"iso-tpm-persistent-state.xml",
+			func(c *Config) {
+				c.TPM = true
+				c.TPMModel = "tpm-tis"
+				c.TPMPersistentState = true
+			},
+			func(state multistep.StateBag) {},
+		},
+		{
+			"disk-image.xml",
+			func(c *Config) {
+				c.DiskImage = true
+			},
+			func(state multistep.StateBag) {},
+		},
+	}
+
+	for _, tc := range testcases {
+		config := runTestConfig()
+		tc.Config(config)
+		state := runTestState(t, config)
+		tc.State(state)
+
+		step := &stepRun{ui: state.Get("ui").(packersdk.Ui)}
+		xml, err := step.getXMLDesc(state)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Golden, err)
+		}
+		assertGoldenXML(t, tc.Golden, xml)
+	}
+}
+
+func Test_StepRun_getXMLDesc_XMLFile(t *testing.T) {
+	config := runTestConfig()
+	config.XMLFile = filepath.Join("testdata", "xml-file.tmpl")
+	state := runTestState(t, config)
+	state.Put("cd_path", "/tmp/packer123.iso")
+
+	step := &stepRun{ui: state.Get("ui").(packersdk.Ui)}
+	xml, err := step.getXMLDesc(state)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assertGoldenXML(t, "xml-file.xml", xml)
+}
+
+func Test_StepRun_Cleanup(t *testing.T) {
+	config := runTestConfig()
+	config.DomainType = "persistent"
+	config.Loader = "/usr/share/OVMF/OVMF_CODE.fd"
+	state := runTestState(t, config)
+	d := state.Get("driver").(*DriverMock)
+
+	step := &stepRun{ui: state.Get("ui").(packersdk.Ui)}
+	if _, err := step.getXMLDesc(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	assert.Equal(t, "/output/packer-test_VARS.fd", state.Get("nvram_path"))
+
+	step.Cleanup(state)
+	assert.True(t, d.StopCalled)
+	assert.Equal(t, []bool{true}, d.UndefineCalls)
+
+	// libvirt owns the NVRAM when the disks are in a storage pool
+	config.StoragePool = "default"
+	state = runTestState(t, config)
+	state.Put("volume_paths", []string{"/var/lib/libvirt/images/packer-test"})
+	d = state.Get("driver").(*DriverMock)
+	if _, err := step.getXMLDesc(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	step.Cleanup(state)
+	assert.Equal(t, []bool{false}, d.UndefineCalls)
+}
@@ -0,0 +1,86 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// This step takes screenshots of the running domain every
+// screenshot_interval until it stops, and once more when the build fails,
+// before the domain is destroyed.
+//
+// Uses:
+//   config *config
+//   driver Driver
+//   ui     packersdk.Ui
+//
+// Produces:
+//   screenshotter *screenshotter - Takes screenshots of the domain.
+type stepScreenshot struct {
+	Interval time.Duration
+
+	screenshotter *screenshotter
+	stopCh        chan struct{}
+}
+
+func (s *stepScreenshot) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	driver := state.Get("driver").(Driver)
+
+	s.screenshotter = newScreenshotter(driver, config)
+	state.Put("screenshotter", s.screenshotter)
+
+	if s.Interval > 0 {
+		log.Printf("Taking a screenshot every %s", s.Interval)
+		s.stopCh = make(chan struct{})
+		endCh := driver.DomainEnded()
+		go func() {
+			ticker := time.NewTicker(s.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if _, err := s.screenshotter.Take("interval"); err != nil {
+						log.Printf("%s", err)
+					}
+				case <-s.stopCh:
+					return
+				case <-endCh:
+					// There's nothing left to take a screenshot of
+					return
+				}
+			}
+		}()
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepScreenshot) Cleanup(state multistep.StateBag) {
+	if s.stopCh != nil {
+		close(s.stopCh)
+		s.stopCh = nil
+	}
+	if s.screenshotter == nil {
+		return
+	}
+
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		return
+	}
+
+	ui := state.Get("ui").(packersdk.Ui)
+	path, err := s.screenshotter.Take("failure")
+	if err != nil {
+		log.Printf("%s", err)
+		return
+	}
+	ui.Say(fmt.Sprintf("Saved a screenshot of the failed VM to %s", path))
+}
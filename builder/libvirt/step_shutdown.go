@@ -22,17 +22,25 @@ import (
 //   ui     packersdk.Ui
 //
 // Produces:
-//   <nothing>
+//   domain_shutdown bool - Set once the domain is expected to stop.
 type stepShutdown struct {
 	ShutdownCommand string
+	ShutdownMethod  string
 	ShutdownTimeout time.Duration
 	Comm            *communicator.Config
 }
 
+// shutdownRetryInterval is how often the graceful shutdown request is
+// repeated, since guests that are still booting tend to ignore it.
+var shutdownRetryInterval = 10 * time.Second
+
 func (s *stepShutdown) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	driver := state.Get("driver").(Driver)
 	ui := state.Get("ui").(packersdk.Ui)
 
+	// stepWatchDomain mustn't fail the build from here on
+	state.Put("domain_shutdown", true)
+
 	if s.Comm.Type == "none" {
 		cancelCh := make(chan struct{}, 1)
 		go func() {
@@ -77,6 +85,16 @@ func (s *stepShutdown) Run(ctx context.Context, state multistep.StateBag) multis
 			ui.Error(err.Error())
 			return multistep.ActionHalt
 		}
+	} else if s.ShutdownMethod == "acpi" || s.ShutdownMethod == "agent" {
+		if ok := s.gracefulShutdown(driver, ui); !ok {
+			ui.Error("Timeout while waiting for machine to shut down, stopping it forcefully.")
+			if err := driver.Stop(); err != nil {
+				err := fmt.Errorf("Error stopping VM: %s", err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+		}
 	} else {
 		ui.Say("Halting the virtual machine...")
 		if err := driver.Stop(); err != nil {
@@ -91,4 +109,45 @@ func (s *stepShutdown) Run(ctx context.Context, state multistep.StateBag) multis
 	return multistep.ActionContinue
 }
 
+// gracefulShutdown asks the guest to shut down through libvirt until it is
+// off, and reports whether it went off within the shutdown timeout.
+func (s *stepShutdown) gracefulShutdown(driver Driver, ui packersdk.Ui) bool {
+	via := "ACPI"
+	if s.ShutdownMethod == "agent" {
+		via = "the guest agent"
+	}
+	ui.Say(fmt.Sprintf("Gracefully shutting down the virtual machine via %s...", via))
+
+	if err := driver.Shutdown(s.ShutdownMethod); err != nil {
+		log.Printf("Error requesting shutdown: %s", err)
+	}
+
+	cancelCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(cancelCh)
+
+		timeout := time.After(s.ShutdownTimeout)
+		ticker := time.NewTicker(shutdownRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := driver.Shutdown(s.ShutdownMethod); err != nil {
+					log.Printf("Error requesting shutdown: %s", err)
+				}
+			case <-timeout:
+				return
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+
+	log.Printf("Waiting max %s for shutdown to complete", s.ShutdownTimeout)
+	ok := driver.WaitForShutdown(cancelCh)
+	close(doneCh)
+	return ok
+}
+
 func (s *stepShutdown) Cleanup(state multistep.StateBag) {}
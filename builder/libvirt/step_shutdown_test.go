@@ -67,6 +67,7 @@ func Test_Shutdown_NoShutdownCommand(t *testing.T) {
 
 	step := &stepShutdown{
 		ShutdownCommand: "",
+		ShutdownMethod:  "destroy",
 		ShutdownTimeout: 5 * time.Minute,
 		Comm: &communicator.Config{
 			Type: "ssh",
@@ -86,3 +87,55 @@ func Test_Shutdown_NoShutdownCommand(t *testing.T) {
 		t.Fatalf("Shutdown shouldn't have errored; err: %v", err)
 	}
 }
+
+func Test_Shutdown_ACPI(t *testing.T) {
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", packersdk.TestUi(t))
+	driverMock := new(DriverMock)
+	driverMock.WaitForShutdownState = true
+	state.Put("driver", driverMock)
+
+	step := &stepShutdown{
+		ShutdownMethod:  "acpi",
+		ShutdownTimeout: 5 * time.Minute,
+		Comm: &communicator.Config{
+			Type: "ssh",
+		},
+	}
+	action := step.Run(context.TODO(), state)
+	if action != multistep.ActionContinue {
+		t.Fatalf("Should have successfully shut down.")
+	}
+	if len(driverMock.ShutdownCalls) == 0 || driverMock.ShutdownCalls[0] != "acpi" {
+		t.Fatalf("should have requested an ACPI shutdown, got %v", driverMock.ShutdownCalls)
+	}
+	if driverMock.StopCalled {
+		t.Fatalf("shouldn't have called Stop through the driver.")
+	}
+}
+
+func Test_Shutdown_Agent_timeout(t *testing.T) {
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", packersdk.TestUi(t))
+	driverMock := new(DriverMock)
+	driverMock.WaitForShutdownState = false
+	state.Put("driver", driverMock)
+
+	step := &stepShutdown{
+		ShutdownMethod:  "agent",
+		ShutdownTimeout: time.Millisecond,
+		Comm: &communicator.Config{
+			Type: "ssh",
+		},
+	}
+	action := step.Run(context.TODO(), state)
+	if action != multistep.ActionContinue {
+		t.Fatalf("Should have forcefully shut down.")
+	}
+	if len(driverMock.ShutdownCalls) == 0 || driverMock.ShutdownCalls[0] != "agent" {
+		t.Fatalf("should have requested a guest agent shutdown, got %v", driverMock.ShutdownCalls)
+	}
+	if !driverMock.StopCalled {
+		t.Fatalf("should have called Stop through the driver after the timeout.")
+	}
+}
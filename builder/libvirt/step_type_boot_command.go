@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
@@ -14,19 +16,95 @@ import (
 	"github.com/mitchellh/go-vnc"
 )
 
-const KeyLeftShift uint32 = 0xFFE1
+// bootCommandDirective matches the directives bootcommand doesn't know
+// about: <waitFor "regexp">, <waitFor "regexp" timeout> and <screenshot>.
+// The regexp is a quoted Go string.
+var bootCommandDirective = regexp.MustCompile(`<waitFor\s+("(?:[^"\\]|\\.)*")(?:\s+([0-9a-zµ.]+))?>|<screenshot>`)
+
+// keySequence is implemented by the sequences bootcommand generates.
+type keySequence interface {
+	Do(context.Context, bootcommand.BCDriver) error
+}
+
+// bootCommandStep is either a sequence of keys to type, a regexp to wait
+// for on the serial console or a screenshot to take.
+type bootCommandStep struct {
+	Keys       keySequence
+	WaitFor    *regexp.Regexp
+	Timeout    time.Duration
+	Screenshot bool
+}
+
+// splitBootCommand splits the boot command at its <waitFor> and <screenshot>
+// directives. timeout applies to every <waitFor> without its own timeout.
+func splitBootCommand(command string, timeout time.Duration) ([]bootCommandStep, error) {
+	var steps []bootCommandStep
+
+	addKeys := func(keys string) error {
+		if keys == "" {
+			return nil
+		}
+		seq, err := bootcommand.GenerateExpressionSequence(keys)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, bootCommandStep{Keys: seq})
+		return nil
+	}
+
+	last := 0
+	for _, m := range bootCommandDirective.FindAllStringSubmatchIndex(command, -1) {
+		if err := addKeys(command[last:m[0]]); err != nil {
+			return nil, err
+		}
+		last = m[1]
+
+		if m[2] < 0 {
+			steps = append(steps, bootCommandStep{Screenshot: true})
+			continue
+		}
+
+		pattern, err := strconv.Unquote(command[m[2]:m[3]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid <waitFor> pattern %s: %s", command[m[2]:m[3]], err)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid <waitFor> pattern %q: %s", pattern, err)
+		}
+
+		stepTimeout := timeout
+		if m[4] >= 0 {
+			stepTimeout, err = time.ParseDuration(command[m[4]:m[5]])
+			if err != nil {
+				return nil, fmt.Errorf("invalid <waitFor> timeout: %s", err)
+			}
+		}
+		steps = append(steps, bootCommandStep{WaitFor: re, Timeout: stepTimeout})
+	}
+	if err := addKeys(command[last:]); err != nil {
+		return nil, err
+	}
+
+	return steps, nil
+}
 
 type bootCommandTemplateData struct {
 	HTTPIP   string
 	HTTPPort int
 	Name     string
+	GuestIP  string
 }
 
-// This step "types" the boot command into the VM over VNC.
+// This step "types" the boot command into the VM over VNC, or through the
+// libvirt connection when boot_key_driver is "libvirt".
 //
 // Uses:
 //   config *config
+//   driver Driver
 //   http_port int
+//   screenshotter *screenshotter - Only for <screenshot> directives.
+//   serial_console *serialConsole - Only for <waitFor> directives.
 //   ui     packersdk.Ui
 //   vnc_port int
 //
@@ -39,15 +117,17 @@ func (s *stepTypeBootCommand) Run(ctx context.Context, state multistep.StateBag)
 	debug := state.Get("debug").(bool)
 	httpPort := state.Get("http_port").(int)
 	ui := state.Get("ui").(packersdk.Ui)
-	vncPort := state.Get("vnc_port").(int)
-	vncIP := config.VNCBindAddress
-	vncPassword := state.Get("vnc_password")
 
-	if config.VNCConfig.DisableVNC {
+	if config.VNCConfig.DisableVNC && config.BootKeyDriver != "libvirt" {
 		log.Println("Skipping boot command step...")
 		return multistep.ActionContinue
 	}
 
+	if config.Kernel != "" && len(config.BootCommand) == 0 {
+		log.Println("Booting kernel directly, skipping boot command step...")
+		return multistep.ActionContinue
+	}
+
 	// Wait the for the vm to boot.
 	if int64(config.BootWait) > 0 {
 		ui.Say(fmt.Sprintf("Waiting %s for boot...", config.BootWait))
@@ -64,48 +144,33 @@ func (s *stepTypeBootCommand) Run(ctx context.Context, state multistep.StateBag)
 		pauseFn = state.Get("pauseFn").(multistep.DebugPauseFn)
 	}
 
-	// Connect to VNC
-	ui.Say(fmt.Sprintf("Connecting to VM via VNC (%s:%d)", vncIP, vncPort))
-
-	nc, err := net.Dial("tcp", fmt.Sprintf("%s:%d", vncIP, vncPort))
-	if err != nil {
-		err := fmt.Errorf("Error connecting to VNC: %s", err)
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
-	}
-	defer nc.Close()
-
-	var auth []vnc.ClientAuth
-
-	if vncPassword != nil && len(vncPassword.(string)) > 0 {
-		auth = []vnc.ClientAuth{&vnc.PasswordAuth{Password: vncPassword.(string)}}
+	var d bootcommand.BCDriver
+	via := "VNC"
+	if config.BootKeyDriver == "libvirt" {
+		via = "libvirt"
+		d = newLibvirtKeyDriver(state.Get("driver").(Driver), config.VNCConfig.BootKeyInterval)
 	} else {
-		auth = []vnc.ClientAuth{new(vnc.ClientAuthNone)}
-	}
-
-	c, err := vnc.Client(nc, &vnc.ClientConfig{Auth: auth, Exclusive: false})
-	if err != nil {
-		err := fmt.Errorf("Error handshaking with VNC: %s", err)
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		c, err := s.connectVNC(state)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		defer c.Close()
+		d = bootcommand.NewVNCDriver(c, config.VNCConfig.BootKeyInterval)
 	}
-	defer c.Close()
-
-	log.Printf("Connected to VNC desktop: %s", c.DesktopName)
 
 	hostIP := state.Get("http_ip").(string)
+	guestIP, _ := state.Get("guest_ip").(string)
 	configCtx := config.ctx
 	configCtx.Data = &bootCommandTemplateData{
 		hostIP,
 		httpPort,
 		config.VMName,
+		guestIP,
 	}
 
-	d := bootcommand.NewVNCDriver(c, config.VNCConfig.BootKeyInterval)
-
-	ui.Say("Typing the boot command over VNC...")
+	ui.Say(fmt.Sprintf("Typing the boot command over %s...", via))
 	command, err := interpolate.Render(config.VNCConfig.FlatBootCommand(), &configCtx)
 	if err != nil {
 		err := fmt.Errorf("Error preparing boot command: %s", err)
@@ -114,7 +179,7 @@ func (s *stepTypeBootCommand) Run(ctx context.Context, state multistep.StateBag)
 		return multistep.ActionHalt
 	}
 
-	seq, err := bootcommand.GenerateExpressionSequence(command)
+	steps, err := splitBootCommand(command, config.BootWaitForTimeout)
 	if err != nil {
 		err := fmt.Errorf("Error generating boot command: %s", err)
 		state.Put("error", err)
@@ -122,11 +187,21 @@ func (s *stepTypeBootCommand) Run(ctx context.Context, state multistep.StateBag)
 		return multistep.ActionHalt
 	}
 
-	if err := seq.Do(ctx, d); err != nil {
-		err := fmt.Errorf("Error running boot command: %s", err)
-		state.Put("error", err)
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+	for _, step := range steps {
+		switch {
+		case step.WaitFor != nil:
+			err = s.waitFor(ctx, state, step)
+		case step.Screenshot:
+			err = s.screenshot(state)
+		default:
+			err = step.Keys.Do(ctx, d)
+		}
+		if err != nil {
+			err := fmt.Errorf("Error running boot command: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
 	}
 
 	if pauseFn != nil {
@@ -136,4 +211,66 @@ func (s *stepTypeBootCommand) Run(ctx context.Context, state multistep.StateBag)
 	return multistep.ActionContinue
 }
 
+// waitFor blocks until the serial console prints the text the step waits for.
+func (s *stepTypeBootCommand) waitFor(ctx context.Context, state multistep.StateBag, step bootCommandStep) error {
+	ui := state.Get("ui").(packersdk.Ui)
+	console, ok := state.GetOk("serial_console")
+	if !ok {
+		return fmt.Errorf("<waitFor> needs the serial console of the VM")
+	}
+
+	ui.Say(fmt.Sprintf("Waiting up to %s for %q on the serial console...", step.Timeout, step.WaitFor))
+	return console.(*serialConsole).WaitFor(ctx, step.WaitFor, step.Timeout)
+}
+
+// screenshot saves a screenshot of the domain for a <screenshot> directive.
+func (s *stepTypeBootCommand) screenshot(state multistep.StateBag) error {
+	ui := state.Get("ui").(packersdk.Ui)
+	raw, ok := state.GetOk("screenshotter")
+	if !ok {
+		return fmt.Errorf("<screenshot> needs the screenshotter of the VM")
+	}
+
+	path, err := raw.(*screenshotter).Take("boot-command")
+	if err != nil {
+		return err
+	}
+	ui.Say(fmt.Sprintf("Saved screenshot to %s", path))
+	return nil
+}
+
+// connectVNC connects to the VNC server of the domain. Closing the returned
+// client also closes the underlying connection.
+func (s *stepTypeBootCommand) connectVNC(state multistep.StateBag) (*vnc.ClientConn, error) {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packersdk.Ui)
+	vncPort := state.Get("vnc_port").(int)
+	vncIP := config.VNCBindAddress
+	vncPassword := state.Get("vnc_password")
+
+	ui.Say(fmt.Sprintf("Connecting to VM via VNC (%s:%d)", vncIP, vncPort))
+
+	nc, err := net.Dial("tcp", net.JoinHostPort(vncIP, strconv.Itoa(vncPort)))
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to VNC: %s", err)
+	}
+
+	var auth []vnc.ClientAuth
+
+	if vncPassword != nil && len(vncPassword.(string)) > 0 {
+		auth = []vnc.ClientAuth{&vnc.PasswordAuth{Password: vncPassword.(string)}}
+	} else {
+		auth = []vnc.ClientAuth{new(vnc.ClientAuthNone)}
+	}
+
+	c, err := vnc.Client(nc, &vnc.ClientConfig{Auth: auth, Exclusive: false})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("Error handshaking with VNC: %s", err)
+	}
+
+	log.Printf("Connected to VNC desktop: %s", c.DesktopName)
+	return c, nil
+}
+
 func (*stepTypeBootCommand) Cleanup(multistep.StateBag) {}
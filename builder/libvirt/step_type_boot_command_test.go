@@ -0,0 +1,96 @@
+package libvirt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StepTypeBootCommand_SkipKernel(t *testing.T) {
+	config := runTestConfig()
+	config.Kernel = "/var/lib/libvirt/boot/vmlinuz"
+	state := runTestState(t, config)
+	state.Put("debug", false)
+	state.Put("http_port", 8080)
+
+	step := &stepTypeBootCommand{}
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have skipped the boot command when booting the kernel directly")
+	}
+	if _, ok := state.GetOk("error"); ok {
+		t.Fatal("should NOT have error")
+	}
+}
+
+func Test_StepTypeBootCommand_Libvirt(t *testing.T) {
+	config := runTestConfig()
+	config.BootKeyDriver = "libvirt"
+	config.DisableVNC = true
+	config.BootCommand = []string{"<enter>"}
+	config.BootKeyInterval = time.Nanosecond
+	state := runTestState(t, config)
+	state.Put("debug", false)
+	state.Put("http_ip", "192.168.122.1")
+	state.Put("http_port", 8080)
+
+	step := &stepTypeBootCommand{}
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue: %v", state.Get("error"))
+	}
+	d := state.Get("driver").(*DriverMock)
+	assert.Equal(t, [][]uint32{{28}}, d.SendKeyCalls)
+}
+
+func Test_splitBootCommand(t *testing.T) {
+	steps, err := splitBootCommand(`<esc><waitFor "boot: ">linux ks=x<enter><waitFor "login:\\s*$" 30s>root`, time.Minute)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(steps) != 5 {
+		t.Fatalf("should have 5 steps, got %d", len(steps))
+	}
+	assert.NotNil(t, steps[0].Keys)
+	assert.Equal(t, "boot: ", steps[1].WaitFor.String())
+	assert.Equal(t, time.Minute, steps[1].Timeout)
+	assert.NotNil(t, steps[2].Keys)
+	assert.Equal(t, `login:\s*$`, steps[3].WaitFor.String())
+	assert.Equal(t, 30*time.Second, steps[3].Timeout)
+	assert.NotNil(t, steps[4].Keys)
+
+	steps, err = splitBootCommand(`<esc><screenshot><enter>`, time.Minute)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(steps) != 3 || !steps[1].Screenshot {
+		t.Fatalf("should have a screenshot step: %#v", steps)
+	}
+
+	if _, err := splitBootCommand(`<waitFor "(">`, time.Minute); err == nil {
+		t.Fatal("should have error for an invalid regexp")
+	}
+}
+
+func Test_StepTypeBootCommand_WaitFor(t *testing.T) {
+	config := runTestConfig()
+	config.BootKeyDriver = "libvirt"
+	config.BootCommand = []string{`<waitFor "boot:">a<waitFor "boot:" 10ms>b`}
+	config.BootKeyInterval = time.Nanosecond
+	state := runTestState(t, config)
+	state.Put("debug", false)
+	state.Put("http_ip", "192.168.122.1")
+	state.Put("http_port", 8080)
+
+	console := newSerialConsole()
+	console.Write([]byte("ISOLINUX\r\nboot: "))
+	state.Put("serial_console", console)
+
+	step := &stepTypeBootCommand{}
+	if action := step.Run(context.TODO(), state); action != multistep.ActionHalt {
+		t.Fatalf("Should have timed out waiting for the second prompt")
+	}
+	d := state.Get("driver").(*DriverMock)
+	assert.Equal(t, [][]uint32{{30}}, d.SendKeyCalls)
+}
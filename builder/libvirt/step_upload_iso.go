@@ -0,0 +1,218 @@
+package libvirt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// This step uploads the downloaded ISO into a libvirt storage pool, so that
+// it can be attached to the domain when libvirtd runs on another host.
+// ISOs with a checksum are kept in the pool and reused by later builds.
+// They're uploaded under a temporary name first and only copied to the
+// cached volume once the upload is complete.
+//
+// Uses:
+//   driver   Driver
+//   iso_path string
+//   ui       packersdk.Ui
+//
+// Produces:
+//   iso_volume_path string - The path of the ISO volume on the hypervisor.
+type stepUploadISO struct {
+	ISOChecksum string
+	StoragePool string
+	VMName      string
+
+	volume string
+}
+
+func (s *stepUploadISO) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if s.StoragePool == "" {
+		return multistep.ActionContinue
+	}
+
+	driver := state.Get("driver").(Driver)
+	isoPath := state.Get("iso_path").(string)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	info, err := os.Stat(isoPath)
+	if err != nil {
+		err := fmt.Errorf("Error reading ISO: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	size := uint64(info.Size())
+
+	name, cached, err := s.volumeName(isoPath)
+	if err != nil {
+		err := fmt.Errorf("Error hashing ISO: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	path, capacity, err := driver.LookupVolume(s.StoragePool, name)
+	if err != nil {
+		err := fmt.Errorf("Error looking up ISO volume: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	if path != "" && cached && capacity == size {
+		ui.Say(fmt.Sprintf("Using ISO volume %s already in storage pool %s", name, s.StoragePool))
+		state.Put("iso_volume_path", path)
+		return multistep.ActionContinue
+	}
+
+	// The cached volume may be the CD-ROM of another build that is running
+	// right now, so it's never deleted here
+	if path != "" && cached {
+		ui.Error(fmt.Sprintf("ISO volume %s in storage pool %s doesn't match the local ISO, "+
+			"uploading the ISO for this build only; delete the volume to cache the ISO again", name, s.StoragePool))
+		name, cached = s.VMName+".iso", false
+		path = ""
+		if err := s.deleteStale(driver, name); err != nil {
+			err := fmt.Errorf("Error deleting stale ISO volume: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+	if path != "" {
+		log.Printf("Replacing ISO volume %s left behind by an earlier build", name)
+		if err := driver.DeleteVolume(s.StoragePool, name); err != nil {
+			err := fmt.Errorf("Error deleting stale ISO volume: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	// A cached volume is only created from a complete upload, so an upload
+	// that is cut short is never reused by later builds
+	uploadName := name
+	if cached {
+		uploadName = s.VMName + ".iso.part"
+		if err := s.deleteStale(driver, uploadName); err != nil {
+			err := fmt.Errorf("Error deleting stale ISO volume: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Uploading ISO to storage pool %s...", s.StoragePool))
+	path, err = s.upload(driver, uploadName, isoPath, size)
+	if err != nil {
+		err := fmt.Errorf("Error uploading ISO: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	if !cached {
+		s.volume = name
+		state.Put("iso_volume_path", path)
+		return multistep.ActionContinue
+	}
+
+	xml, err := volumeXML(name, "raw", size, "", "")
+	if err == nil {
+		path, err = driver.CreateVolume(s.StoragePool, xml, uploadName)
+	}
+	if err := driver.DeleteVolume(s.StoragePool, uploadName); err != nil {
+		ui.Error(fmt.Sprintf("Error deleting volume %s: %s", uploadName, err))
+	}
+	// A parallel build uploading the same ISO may have created the cached
+	// volume first
+	if isVolumeExists(err) {
+		var capacity uint64
+		path, capacity, err = driver.LookupVolume(s.StoragePool, name)
+		if err == nil && (path == "" || capacity != size) {
+			err = fmt.Errorf("volume %s was replaced while uploading", name)
+		}
+		if err == nil {
+			ui.Say(fmt.Sprintf("Using ISO volume %s another build uploaded", name))
+		}
+	}
+	if err != nil {
+		err := fmt.Errorf("Error creating ISO volume: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	state.Put("iso_volume_path", path)
+
+	return multistep.ActionContinue
+}
+
+// deleteStale deletes the volume of this build an interrupted upload of an
+// earlier build left behind.
+func (s *stepUploadISO) deleteStale(driver Driver, name string) error {
+	path, _, err := driver.LookupVolume(s.StoragePool, name)
+	if err != nil || path == "" {
+		return err
+	}
+	log.Printf("Deleting ISO volume %s left behind by an earlier build", name)
+	return driver.DeleteVolume(s.StoragePool, name)
+}
+
+// upload streams the ISO into a new volume and returns its path.
+func (s *stepUploadISO) upload(driver Driver, name, isoPath string, size uint64) (string, error) {
+	xml, err := volumeXML(name, "raw", size, "", "")
+	if err != nil {
+		return "", err
+	}
+	path, err := driver.CreateVolume(s.StoragePool, xml, "")
+	if err != nil {
+		return "", err
+	}
+	if err := driver.UploadVolume(s.StoragePool, name, isoPath); err != nil {
+		driver.DeleteVolume(s.StoragePool, name)
+		return "", err
+	}
+	return path, nil
+}
+
+// volumeName names the ISO volume after the SHA-256 of the ISO so later
+// builds can find it again, whatever form iso_checksum has. A sha256
+// iso_checksum is used as is, any other ISO is hashed. Without a checksum the
+// ISO isn't verified and the volume belongs to this build only.
+func (s *stepUploadISO) volumeName(isoPath string) (string, bool, error) {
+	if s.ISOChecksum == "" || s.ISOChecksum == "none" {
+		return s.VMName + ".iso", false, nil
+	}
+
+	if value := strings.TrimPrefix(s.ISOChecksum, "sha256:"); value != s.ISOChecksum {
+		if b, err := hex.DecodeString(value); err == nil && len(b) == sha256.Size {
+			return "packer-" + hex.EncodeToString(b) + ".iso", true, nil
+		}
+	}
+
+	f, err := os.Open(isoPath)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", false, err
+	}
+	return "packer-" + hex.EncodeToString(h.Sum(nil)) + ".iso", true, nil
+}
+
+func (s *stepUploadISO) Cleanup(state multistep.StateBag) {
+	if s.volume != "" {
+		deleteVolumes(state, s.StoragePool, []string{s.volume})
+	}
+}
@@ -0,0 +1,188 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/stretchr/testify/assert"
+)
+
+func uploadISOTestState(t *testing.T, d *DriverMock) multistep.StateBag {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tf.Write([]byte("0123456789"))
+	tf.Close()
+	t.Cleanup(func() { os.Remove(tf.Name()) })
+
+	state := testState(t)
+	state.Put("driver", d)
+	state.Put("iso_path", tf.Name())
+	return state
+}
+
+func Test_StepUploadISO_Skip(t *testing.T) {
+	d := new(DriverMock)
+	state := uploadISOTestState(t, d)
+	step := &stepUploadISO{ISOChecksum: "md5:abc"}
+
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+	if len(d.LookupVolumeCalls) > 0 || len(d.UploadVolumeCalls) > 0 {
+		t.Fatalf("Should have skipped step since no storage pool is set")
+	}
+	if _, ok := state.GetOk("iso_volume_path"); ok {
+		t.Fatalf("Should not have set iso_volume_path")
+	}
+}
+
+func Test_StepUploadISO_Reuse(t *testing.T) {
+	d := new(DriverMock)
+	d.LookupVolumePath = "/pool/cached.iso"
+	d.LookupVolumeCapacity = 10
+	state := uploadISOTestState(t, d)
+	step := &stepUploadISO{ISOChecksum: "md5:abc", StoragePool: "default", VMName: "packer-foo"}
+
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+	if len(d.UploadVolumeCalls) > 0 {
+		t.Fatalf("Should have reused the existing volume")
+	}
+	assert.Equal(t, "/pool/cached.iso", state.Get("iso_volume_path"))
+
+	step.Cleanup(state)
+	if len(d.DeleteVolumeCalls) > 0 {
+		t.Fatalf("Should have kept the cached volume")
+	}
+}
+
+func Test_StepUploadISO_Upload(t *testing.T) {
+	d := new(DriverMock)
+	d.CreateVolumeResults = []string{"/pool/packer-foo.iso.part", "/pool/new.iso"}
+	state := uploadISOTestState(t, d)
+	step := &stepUploadISO{ISOChecksum: "md5:abc", StoragePool: "default", VMName: "packer-foo"}
+
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+	name, cached, err := step.volumeName(state.Get("iso_path").(string))
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, []string{name, "packer-foo.iso.part"}, d.LookupVolumeCalls)
+	assert.Equal(t, [][]string{{"default", "packer-foo.iso.part", state.Get("iso_path").(string)}}, d.UploadVolumeCalls)
+	assert.Len(t, d.CreateVolumeCalls, 2)
+	assert.Equal(t, "packer-foo.iso.part", d.CreateVolumeCalls[1][2], "Should have cloned the complete upload")
+	assert.Equal(t, []string{"packer-foo.iso.part"}, d.DeleteVolumeCalls, "Should have deleted the temporary volume")
+	assert.Equal(t, "/pool/new.iso", state.Get("iso_volume_path"))
+
+	step.Cleanup(state)
+	assert.Equal(t, []string{"packer-foo.iso.part"}, d.DeleteVolumeCalls, "Should have kept the cached volume")
+}
+
+func Test_StepUploadISO_CreatedByOtherBuild(t *testing.T) {
+	d := new(DriverMock)
+	d.LookupVolumePaths = []string{"", "", "/pool/cached.iso"}
+	d.LookupVolumeCapacity = 10
+	d.CreateVolumeResults = []string{"/pool/packer-foo.iso.part"}
+	d.CreateVolumeErrs = []error{nil, libvirt.Error{Code: uint32(libvirt.ErrStorageVolExist)}}
+	state := uploadISOTestState(t, d)
+	step := &stepUploadISO{ISOChecksum: "md5:abc", StoragePool: "default", VMName: "packer-foo"}
+
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue: %s", state.Get("error"))
+	}
+	assert.Len(t, d.LookupVolumeCalls, 3, "Should have looked the cached volume up again")
+	assert.Equal(t, []string{"packer-foo.iso.part"}, d.DeleteVolumeCalls)
+	assert.Equal(t, "/pool/cached.iso", state.Get("iso_volume_path"))
+}
+
+func Test_StepUploadISO_Stale(t *testing.T) {
+	d := new(DriverMock)
+	d.LookupVolumePath = "/pool/stale.iso"
+	d.LookupVolumeCapacity = 5
+	d.CreateVolumeResults = []string{"/pool/packer-foo.iso"}
+	state := uploadISOTestState(t, d)
+	step := &stepUploadISO{ISOChecksum: "md5:abc", StoragePool: "default", VMName: "packer-foo"}
+
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+	// The stale cached volume may be in use by another build
+	assert.Equal(t, []string{"packer-foo.iso"}, d.DeleteVolumeCalls, "Should only have replaced the volume of this build")
+	assert.Equal(t, [][]string{{"default", "packer-foo.iso", state.Get("iso_path").(string)}}, d.UploadVolumeCalls)
+	assert.Len(t, d.CreateVolumeCalls, 1)
+	assert.Equal(t, "/pool/packer-foo.iso", state.Get("iso_volume_path"))
+
+	step.Cleanup(state)
+	assert.Equal(t, []string{"packer-foo.iso", "packer-foo.iso"}, d.DeleteVolumeCalls)
+}
+
+func Test_StepUploadISO_UploadFailed(t *testing.T) {
+	d := new(DriverMock)
+	d.UploadVolumeErr = errors.New("connection lost")
+	state := uploadISOTestState(t, d)
+	step := &stepUploadISO{ISOChecksum: "md5:abc", StoragePool: "default", VMName: "packer-foo"}
+
+	if action := step.Run(context.TODO(), state); action != multistep.ActionHalt {
+		t.Fatalf("Should have gotten an ActionHalt")
+	}
+	assert.Len(t, d.CreateVolumeCalls, 1, "Should not have created the cached volume")
+	assert.Equal(t, []string{"packer-foo.iso.part"}, d.DeleteVolumeCalls)
+}
+
+func Test_StepUploadISO_volumeName(t *testing.T) {
+	d := new(DriverMock)
+	state := uploadISOTestState(t, d)
+	isoPath := state.Get("iso_path").(string)
+	step := &stepUploadISO{ISOChecksum: "file:./SHA256SUMS", VMName: "packer-foo"}
+
+	name, _, err := step.volumeName(isoPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "packer-84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882.iso", name)
+
+	if err := ioutil.WriteFile(isoPath, []byte("9876543210"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	changed, _, err := step.volumeName(isoPath)
+	assert.NoError(t, err)
+	assert.NotEqual(t, name, changed, "Should have named the volume after the ISO content")
+}
+
+func Test_StepUploadISO_volumeNameSHA256(t *testing.T) {
+	d := new(DriverMock)
+	state := uploadISOTestState(t, d)
+	sum := "ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789"
+	step := &stepUploadISO{ISOChecksum: "sha256:" + sum, VMName: "packer-foo"}
+
+	// The file doesn't match the checksum, so the name can only come from it
+	name, cached, err := step.volumeName(state.Get("iso_path").(string))
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "packer-abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789.iso", name)
+
+	_, _, err = step.volumeName("/nonexistent.iso")
+	assert.NoError(t, err, "Shouldn't have read the ISO")
+}
+
+func Test_StepUploadISO_NoChecksum(t *testing.T) {
+	d := new(DriverMock)
+	d.CreateVolumeResults = []string{"/pool/packer-foo.iso"}
+	state := uploadISOTestState(t, d)
+	step := &stepUploadISO{ISOChecksum: "none", StoragePool: "default", VMName: "packer-foo"}
+
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+	assert.Equal(t, []string{"packer-foo.iso"}, d.LookupVolumeCalls)
+
+	step.Cleanup(state)
+	assert.Equal(t, []string{"packer-foo.iso"}, d.DeleteVolumeCalls, "Should have deleted the per-build volume")
+}
@@ -0,0 +1,104 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// This step uploads the floppy and the CD built from cd_files into a libvirt
+// storage pool, so that they can be attached to the domain when libvirtd
+// runs on another host. The volumes belong to this build only.
+//
+// Uses:
+//   cd_path     string
+//   driver      Driver
+//   floppy_path string
+//   ui          packersdk.Ui
+//
+// Produces:
+//   cd_volume_path     string - The path of the CD volume on the hypervisor.
+//   floppy_volume_path string - The path of the floppy volume on the
+//                               hypervisor.
+type stepUploadMedia struct {
+	StoragePool string
+	VMName      string
+
+	volumes []string
+}
+
+func (s *stepUploadMedia) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if s.StoragePool == "" {
+		return multistep.ActionContinue
+	}
+
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	media := []struct {
+		pathKey   string
+		resultKey string
+		name      string
+	}{
+		{"floppy_path", "floppy_volume_path", s.VMName + ".vfd"},
+		{"cd_path", "cd_volume_path", s.VMName + "-cd.iso"},
+	}
+	for _, m := range media {
+		localPath, ok := state.GetOk(m.pathKey)
+		if !ok {
+			continue
+		}
+
+		ui.Say(fmt.Sprintf("Uploading %s to storage pool %s...", m.name, s.StoragePool))
+		path, err := s.upload(driver, m.name, localPath.(string))
+		if err != nil {
+			err := fmt.Errorf("Error uploading %s: %s", m.name, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		state.Put(m.resultKey, path)
+	}
+
+	return multistep.ActionContinue
+}
+
+// upload streams the local file into a new volume, replacing a volume of
+// the same name left behind by an earlier build, and returns its path.
+func (s *stepUploadMedia) upload(driver Driver, name, localPath string) (string, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return "", err
+	}
+
+	path, _, err := driver.LookupVolume(s.StoragePool, name)
+	if err != nil {
+		return "", err
+	}
+	if path != "" {
+		if err := driver.DeleteVolume(s.StoragePool, name); err != nil {
+			return "", err
+		}
+	}
+
+	xml, err := volumeXML(name, "raw", uint64(info.Size()), "", "")
+	if err != nil {
+		return "", err
+	}
+	path, err = driver.CreateVolume(s.StoragePool, xml, "")
+	if err != nil {
+		return "", err
+	}
+	s.volumes = append(s.volumes, name)
+	if err := driver.UploadVolume(s.StoragePool, name, localPath); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (s *stepUploadMedia) Cleanup(state multistep.StateBag) {
+	deleteVolumes(state, s.StoragePool, s.volumes)
+}
@@ -0,0 +1,53 @@
+package libvirt
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StepUploadMedia(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tf.Write([]byte("0123456789"))
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	d := new(DriverMock)
+	d.CreateVolumeResults = []string{"/pool/packer-foo-cd.iso"}
+	state := testState(t)
+	state.Put("driver", d)
+	state.Put("cd_path", tf.Name())
+	step := &stepUploadMedia{StoragePool: "default", VMName: "packer-foo"}
+
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+	assert.Equal(t, [][]string{{"default", "packer-foo-cd.iso", tf.Name()}}, d.UploadVolumeCalls)
+	assert.Equal(t, "/pool/packer-foo-cd.iso", state.Get("cd_volume_path"))
+	if _, ok := state.GetOk("floppy_volume_path"); ok {
+		t.Fatalf("Should not have uploaded a floppy")
+	}
+
+	step.Cleanup(state)
+	assert.Equal(t, []string{"packer-foo-cd.iso"}, d.DeleteVolumeCalls)
+}
+
+func Test_StepUploadMedia_Skip(t *testing.T) {
+	d := new(DriverMock)
+	state := testState(t)
+	state.Put("driver", d)
+	state.Put("cd_path", "/tmp/packer123.iso")
+	step := &stepUploadMedia{VMName: "packer-foo"}
+
+	if action := step.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have gotten an ActionContinue")
+	}
+	assert.Empty(t, d.UploadVolumeCalls)
+}
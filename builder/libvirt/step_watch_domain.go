@@ -0,0 +1,71 @@
+package libvirt
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// This step halts the build as soon as the domain stops running before it is
+// shut down, instead of letting the next steps wait for their timeouts. With
+// the "none" communicator the guest is expected to power itself off, so the
+// domain isn't watched.
+//
+// Uses:
+//   config *config
+//   domain_shutdown bool
+//   driver Driver
+//   ui     packersdk.Ui
+//
+// Produces:
+//   domain_error error - Why the domain stopped, when it did too early.
+type stepWatchDomain struct {
+	// Cancel cancels the context of the build.
+	Cancel context.CancelFunc
+
+	stopCh chan struct{}
+}
+
+func (s *stepWatchDomain) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	driver := state.Get("driver").(Driver)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	if config.Comm.Type == "none" {
+		log.Println("The none communicator waits for the VM to stop, not watching it")
+		return multistep.ActionContinue
+	}
+
+	endCh := driver.DomainEnded()
+	s.stopCh = make(chan struct{})
+	go func(stopCh <-chan struct{}) {
+		select {
+		case <-endCh:
+		case <-stopCh:
+			return
+		}
+
+		if _, ok := state.GetOk("domain_shutdown"); ok {
+			return
+		}
+		if _, ok := state.GetOk("error"); ok {
+			return
+		}
+		err := fmt.Errorf("The VM stopped unexpectedly: %s", driver.DomainEndError())
+		state.Put("domain_error", err)
+		ui.Error(err.Error())
+		s.Cancel()
+	}(s.stopCh)
+
+	return multistep.ActionContinue
+}
+
+func (s *stepWatchDomain) Cleanup(state multistep.StateBag) {
+	if s.stopCh != nil {
+		close(s.stopCh)
+		s.stopCh = nil
+	}
+}
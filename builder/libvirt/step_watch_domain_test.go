@@ -0,0 +1,58 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StepWatchDomain(t *testing.T) {
+	state := runTestState(t, runTestConfig())
+	d := state.Get("driver").(*DriverMock)
+	d.DomainEndedCh = make(chan struct{})
+	d.DomainEndErrorErr = errors.New("domain packer-test is crashed: the guest panicked")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	step := &stepWatchDomain{Cancel: cancel}
+	if action := step.Run(ctx, state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued: %v", state.Get("error"))
+	}
+	defer step.Cleanup(state)
+
+	close(d.DomainEndedCh)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("should have cancelled the build")
+	}
+	assert.EqualError(t, state.Get("domain_error").(error),
+		"The VM stopped unexpectedly: domain packer-test is crashed: the guest panicked")
+}
+
+func Test_StepWatchDomain_Shutdown(t *testing.T) {
+	state := runTestState(t, runTestConfig())
+	d := state.Get("driver").(*DriverMock)
+	d.DomainEndedCh = make(chan struct{})
+
+	cancelled := make(chan struct{})
+	step := &stepWatchDomain{Cancel: func() { close(cancelled) }}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Should have continued: %v", state.Get("error"))
+	}
+
+	state.Put("domain_shutdown", true)
+	close(d.DomainEndedCh)
+	select {
+	case <-cancelled:
+		t.Fatal("shouldn't have cancelled the build")
+	case <-time.After(100 * time.Millisecond):
+	}
+	step.Cleanup(state)
+	if _, ok := state.GetOk("domain_error"); ok {
+		t.Fatal("shouldn't have failed the build")
+	}
+}
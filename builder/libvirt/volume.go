@@ -0,0 +1,91 @@
+package libvirt
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var diskSizeUnits = map[string]uint64{
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// diskSizeBytes converts a disk size as accepted by disk_size and
+// disk_additional_size into bytes. Sizes without a unit are megabytes.
+func diskSizeBytes(size string) (uint64, error) {
+	m := regexp.MustCompile(`^(\d+)([bkmgt]?)$`).FindStringSubmatch(strings.ToLower(size))
+	if m == nil {
+		return 0, fmt.Errorf("invalid disk size %q", size)
+	}
+	n, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := m[2]
+	if unit == "" {
+		unit = "m"
+	}
+	return n * diskSizeUnits[unit], nil
+}
+
+// volumeXML renders the storage volume definition used with
+// StorageVolCreateXML and StorageVolCreateXMLFrom.
+func volumeXML(name, format string, capacity uint64, backingPath, backingFormat string) (string, error) {
+	type volumeFormat struct {
+		Type string `xml:"type,attr"`
+	}
+	type volumeTarget struct {
+		Path   string       `xml:"path,omitempty"`
+		Format volumeFormat `xml:"format"`
+	}
+	type volume struct {
+		XMLName  xml.Name `xml:"volume"`
+		Name     string   `xml:"name"`
+		Capacity struct {
+			Unit  string `xml:"unit,attr"`
+			Value uint64 `xml:",chardata"`
+		} `xml:"capacity"`
+		Target       volumeTarget  `xml:"target"`
+		BackingStore *volumeTarget `xml:"backingStore,omitempty"`
+	}
+
+	v := volume{Name: name, Target: volumeTarget{Format: volumeFormat{format}}}
+	v.Capacity.Unit = "bytes"
+	v.Capacity.Value = capacity
+	if backingPath != "" {
+		v.BackingStore = &volumeTarget{Path: backingPath, Format: volumeFormat{backingFormat}}
+	}
+
+	out, err := xml.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// detectImageFormat reports whether the local file is a qcow2 image by
+// looking at its magic, treating everything else as raw.
+func detectImageFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := f.Read(magic); err != nil {
+		return "", err
+	}
+	if bytes.Equal(magic, []byte{'Q', 'F', 'I', 0xfb}) {
+		return "qcow2", nil
+	}
+	return "raw", nil
+}
@@ -0,0 +1,47 @@
+package libvirt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_diskSizeBytes(t *testing.T) {
+	testcases := map[string]uint64{
+		"512":    512 << 20,
+		"40960M": 40960 << 20,
+		"10g":    10 << 30,
+		"1T":     1 << 40,
+		"4096b":  4096,
+		"8k":     8 << 10,
+	}
+	for size, expected := range testcases {
+		got, err := diskSizeBytes(size)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", size, err)
+		}
+		assert.Equal(t, expected, got, size)
+	}
+
+	if _, err := diskSizeBytes("12x"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func Test_volumeXML(t *testing.T) {
+	xml, err := volumeXML("target", "qcow2", 1024, "/pool/target-source", "raw")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assert.Equal(t, `<volume>
+	<name>target</name>
+	<capacity unit="bytes">1024</capacity>
+	<target>
+		<format type="qcow2"></format>
+	</target>
+	<backingStore>
+		<path>/pool/target-source</path>
+		<format type="raw"></format>
+	</backingStore>
+</volume>`, xml)
+}